@@ -0,0 +1,132 @@
+// Package address checks skycoin addresses locally, without asking a node, so
+// that typos can be caught before any coins are committed to them.
+//
+// An address is the base58 encoding of 25 bytes: the 20 byte hash of the
+// public key, the version byte and 4 bytes of checksum, which are the first
+// bytes of sha256 of the key and the version.
+package address
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// The only version of addresses skycoin has.
+const Version = 0
+
+var Empty = errors.New("the address is empty")
+var WrongLength = errors.New("the address has the wrong length, it may have been cut off or pasted twice")
+var WrongVersion = errors.New("this is not a skycoin address")
+var BadChecksum = errors.New("the address has a typo in it, its checksum does not match")
+
+// Returned when the address has a character which base58 does not use.
+type InvalidCharacter struct {
+	Char     rune
+	Position int // 1-based
+}
+
+func (e *InvalidCharacter) Error() string {
+	return fmt.Sprintf(
+		"'%c' at position %d cannot be in a skycoin address (they never have 0, O, I and l in them)",
+		e.Char, e.Position,
+	)
+}
+
+type Address struct {
+	Version byte
+	Key     [20]byte // ripemd160 of the public key
+}
+
+func decodeBase58(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for i, c := range s {
+		digit := strings.IndexRune(alphabet, c)
+		if digit < 0 {
+			return nil, &InvalidCharacter{c, i + 1}
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+
+	// leading zero bytes are encoded as leading '1's
+	var zeros int
+	for zeros < len(s) && s[zeros] == alphabet[0] {
+		zeros++
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+func checksum(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:4]
+}
+
+// Decodes and checks the address. The errors are meant to be shown to the
+// user as they are.
+func Decode(s string) (Address, error) {
+	var a Address
+
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return a, Empty
+	}
+
+	b, err := decodeBase58(s)
+	if err != nil {
+		return a, err
+	}
+	if len(b) != 25 {
+		return a, WrongLength
+	}
+	if !bytes.Equal(checksum(b[:21]), b[21:]) {
+		return a, BadChecksum
+	}
+	if b[20] != Version {
+		return a, WrongVersion
+	}
+
+	copy(a.Key[:], b[:20])
+	a.Version = b[20]
+	return a, nil
+}
+
+// Returns nil if the address is a valid skycoin address.
+func Validate(s string) error {
+	_, err := Decode(s)
+	return err
+}
+
+func (a Address) String() string {
+	b := make([]byte, 0, 25)
+	b = append(b, a.Key[:]...)
+	b = append(b, a.Version)
+	b = append(b, checksum(b)...)
+
+	n := new(big.Int).SetBytes(b)
+	radix := big.NewInt(58)
+	digit := new(big.Int)
+
+	var encoded []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, digit)
+		encoded = append(encoded, alphabet[digit.Int64()])
+	}
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		encoded = append(encoded, alphabet[0])
+	}
+
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
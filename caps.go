@@ -0,0 +1,114 @@
+package skyaway
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var CapReached = errors.New("the user has reached the reward cap")
+
+type CapsConfig struct {
+	// The most coins a user may get in a calendar month, e.g. "100", no
+	// cap if empty.
+	MonthlyCoins string `json:"monthly_coins"`
+	// The most events a user may win in the `wins_window`, no cap if
+	// zero.
+	Wins       int      `json:"wins"`
+	WinsWindow Duration `json:"wins_window"`
+}
+
+// Limits how much a single user gets across events. Caps is an eligibility
+// rule, which leaves out the users who have reached a cap already, and the
+// claims are checked against it too.
+type Caps struct {
+	MonthlyCoins Coins // zero for no cap
+	Wins         int   // zero for no cap
+	WinsWindow   time.Duration
+}
+
+// Parses the caps config, returns nil if no caps are configured.
+func (c *CapsConfig) Parse() (*Caps, error) {
+	var caps Caps
+	if c.MonthlyCoins != "" {
+		coins, err := ParseCoins(c.MonthlyCoins)
+		if err != nil || coins <= 0 {
+			return nil, fmt.Errorf("malformed monthly coins cap: %s", c.MonthlyCoins)
+		}
+		caps.MonthlyCoins = coins
+	}
+	if c.Wins > 0 {
+		if !c.WinsWindow.Valid || c.WinsWindow.Duration <= 0 {
+			return nil, fmt.Errorf("the wins cap needs a window")
+		}
+		caps.Wins, caps.WinsWindow = c.Wins, c.WinsWindow.Duration
+	}
+
+	if caps.MonthlyCoins == 0 && caps.Wins == 0 {
+		return nil, nil
+	}
+	return &caps, nil
+}
+
+// How much of the caps a user has used up.
+type CapUsage struct {
+	MonthlyCoins Coins // claimed in this calendar month
+	Wins         int   // events won in the wins window
+}
+
+func capUsage(q sqlx.Ext, userID int, window time.Duration) (*CapUsage, error) {
+	var usage CapUsage
+	err := q.QueryRowx(q.Rebind(`
+		select
+			coalesce(sum(coins) filter (
+				where claimed_at >= date_trunc('month', now())
+			), 0),
+			count(distinct event_id) filter (
+				where claimed_at >= ?
+			)
+		from participant
+		where user_id = ? and claimed_at is not null and coins > 0`),
+		time.Now().Add(-window), userID,
+	).Scan(&usage.MonthlyCoins, &usage.Wins)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count the cap usage: %v", err)
+	}
+	return &usage, nil
+}
+
+// Returns the coins the user may still get this month, or -1 if there is no
+// monthly cap. Returns `CapReached` if the user may not win anymore.
+func (c *Caps) allowance(q sqlx.Ext, userID int) (Coins, error) {
+	if c == nil {
+		return -1, nil
+	}
+
+	usage, err := capUsage(q, userID, c.WinsWindow)
+	if err != nil {
+		return 0, err
+	}
+	if c.Wins > 0 && usage.Wins >= c.Wins {
+		return 0, CapReached
+	}
+	if c.MonthlyCoins == 0 {
+		return -1, nil
+	}
+	if usage.MonthlyCoins >= c.MonthlyCoins {
+		return 0, CapReached
+	}
+	return c.MonthlyCoins - usage.MonthlyCoins, nil
+}
+
+func (c *Caps) Check(tx *sqlx.Tx, event *Event, user *TempUser) (string, error) {
+	_, err := c.allowance(tx, user.ID)
+	if err == CapReached {
+		return "reached the reward cap", nil
+	}
+	return "", err
+}
+
+func (c *Caps) String() string {
+	return fmt.Sprintf("caps:%s/month,%d/%s", c.MonthlyCoins, c.Wins, niceDuration(c.WinsWindow))
+}
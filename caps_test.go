@@ -0,0 +1,42 @@
+package skyaway
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCapsConfigParse(t *testing.T) {
+	week := Duration{7 * 24 * time.Hour, true}
+	for _, test := range []struct {
+		config   CapsConfig
+		expected *Caps
+	}{
+		{CapsConfig{}, nil},
+		{CapsConfig{WinsWindow: week}, nil},
+		{CapsConfig{MonthlyCoins: "100"}, &Caps{MonthlyCoins: 100 * 1000000}},
+		{CapsConfig{MonthlyCoins: "0.5", Wins: 2, WinsWindow: week}, &Caps{
+			MonthlyCoins: 500000, Wins: 2, WinsWindow: week.Duration,
+		}},
+	} {
+		caps, err := test.config.Parse()
+		if err != nil {
+			t.Errorf("%+v: %v", test.config, err)
+			continue
+		}
+		if (caps == nil) != (test.expected == nil) || caps != nil && *caps != *test.expected {
+			t.Errorf("%+v: got %+v, expected %+v", test.config, caps, test.expected)
+		}
+	}
+
+	for _, config := range []CapsConfig{
+		{MonthlyCoins: "lots"},
+		{MonthlyCoins: "0"},
+		{MonthlyCoins: "-1"},
+		{Wins: 3},
+		{Wins: 3, WinsWindow: Duration{0, true}},
+	} {
+		if _, err := config.Parse(); err == nil {
+			t.Errorf("%+v: expected an error", config)
+		}
+	}
+}
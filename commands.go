@@ -14,6 +14,7 @@ func (bot *Bot) setCommandHandlers() {
 	}
 
 	bot.AddPrivateMessageHandler((*Bot).handleDirectMessageFallback)
+	bot.AddPrivateMessageHandler((*Bot).handleQuizAnswer)
 	bot.AddGroupMessageHandler((*Bot).handleDirectMessageFallback)
 }
 
@@ -78,6 +79,36 @@ var commands = Commands{
 		"scheduleevent",
 		(*Bot).handleCommandScheduleEvent,
 	},
+	Command{
+		true,
+		"events",
+		(*Bot).handleCommandEvents,
+	},
+	Command{
+		true,
+		"editevent",
+		(*Bot).handleCommandEditEvent,
+	},
+	Command{
+		true,
+		"extendevent",
+		(*Bot).handleCommandExtendEvent,
+	},
+	Command{
+		true,
+		"shortenevent",
+		(*Bot).handleCommandExtendEvent,
+	},
+	Command{
+		true,
+		"pauseevent",
+		(*Bot).handleCommandPauseEvent,
+	},
+	Command{
+		true,
+		"resumeevent",
+		(*Bot).handleCommandPauseEvent,
+	},
 	Command{
 		true,
 		"settings",
@@ -119,4 +150,94 @@ var commands = Commands{
 		"listwinners",
 		(*Bot).handleCommandListWinners,
 	},
+	Command{
+		false,
+		"raffle",
+		(*Bot).handleCommandRaffle,
+	},
+	Command{
+		true,
+		"verifysplit",
+		(*Bot).handleCommandVerifySplit,
+	},
+	Command{
+		true,
+		"payouts",
+		(*Bot).handleCommandPayouts,
+	},
+	Command{
+		true,
+		"treasury",
+		(*Bot).handleCommandTreasury,
+	},
+	Command{
+		false,
+		"setaddress",
+		(*Bot).handleCommandSetAddress,
+	},
+	Command{
+		false,
+		"myaddress",
+		(*Bot).handleCommandMyAddress,
+	},
+	Command{
+		false,
+		"clearaddress",
+		(*Bot).handleCommandClearAddress,
+	},
+	Command{
+		true,
+		"addresshistory",
+		(*Bot).handleCommandAddressHistory,
+	},
+	Command{
+		true,
+		"activity",
+		(*Bot).handleCommandActivity,
+	},
+	Command{
+		true,
+		"addrecurrence",
+		(*Bot).handleCommandAddRecurrence,
+	},
+	Command{
+		true,
+		"recurrences",
+		(*Bot).handleCommandRecurrences,
+	},
+	Command{
+		true,
+		"pauserecurrence",
+		(*Bot).handleCommandPauseRecurrence,
+	},
+	Command{
+		true,
+		"resumerecurrence",
+		(*Bot).handleCommandPauseRecurrence,
+	},
+	Command{
+		true,
+		"deleterecurrence",
+		(*Bot).handleCommandDeleteRecurrence,
+	},
+	Command{
+		true,
+		"caps",
+		(*Bot).handleCommandCaps,
+	},
+	Command{
+		true,
+		"addquestion",
+		(*Bot).handleCommandAddQuestion,
+	},
+	Command{
+		true,
+		"questions",
+		(*Bot).handleCommandQuestions,
+	},
+	Command{
+		true,
+		"removequestion",
+		(*Bot).handleCommandRemoveQuestion,
+	},
 }
@@ -6,10 +6,16 @@ type DatabaseConfig struct {
 }
 
 type WalletConfig struct {
+	// "rpc" (the default), "dryrun", "csv" or "json"
+	Backend string `json:"backend"`
+
 	RPC       string `json:"rpc"`
 	Address   string `json:"address"`
 	PublicKey string `json:"public_key"`
 	SecretKey string `json:"secret_key"`
+
+	// where the csv and json backends write the payouts to
+	PayoutFile string `json:"payout_file"`
 }
 
 type Config struct {
@@ -17,5 +23,41 @@ type Config struct {
 	Token         string         `json:"token"`
 	ChatID        int64          `json:"chat_id"`
 	Database      DatabaseConfig `json:"database"`
+	Wallet        WalletConfig   `json:"wallet"`
 	AnnounceEvery Duration       `json:"announce_every"`
+	// The countdown milestones of the events which do not set their own,
+	// e.g. "1d,6h,1h,15m,1m", which replace `announce_every` if set.
+	Countdown string `json:"countdown"`
+
+	// How often to retry failed payouts and check for confirmations,
+	// every minute by default.
+	PayoutEvery Duration `json:"payout_every"`
+	// Give up sending a payout after this many attempts, zero to never
+	// give up.
+	PayoutAttempts int `json:"payout_attempts"`
+	// The maximum number of outputs in a transaction of a batch payout
+	// event, 50 by default.
+	PayoutBatchSize int `json:"payout_batch_size"`
+
+	// What to do with events the wallet cannot cover: "refuse" (the
+	// default), "warn" the admins, or "off" to not check at all.
+	TreasuryCheck string `json:"treasury_check"`
+	// How long before the start of a scheduled event to check the funds
+	// again, 10 minutes by default.
+	TreasuryRecheckBefore Duration `json:"treasury_recheck_before"`
+
+	// The eligibility rules of the events which do not set their own, see
+	// ParseRules.
+	Eligibility string `json:"eligibility"`
+
+	// Put the coins left unclaimed in an ended event aside and add them to
+	// the next event.
+	Rollover bool `json:"rollover"`
+
+	// Limits on how much a user gets across events.
+	Caps CapsConfig `json:"caps"`
+
+	// How many wrong answers to a quiz a user may give in an event, 3 by
+	// default.
+	QuizAttempts int `json:"quiz_attempts"`
 }
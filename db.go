@@ -3,12 +3,12 @@ package skyaway
 import (
 	"errors"
 	"fmt"
-	"math/rand"
 	"time"
 
 	"database/sql"
 
 	"strconv"
+	"strings"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -19,40 +19,131 @@ type DB struct {
 
 var NotParticipating = errors.New("the user is not participating in the event")
 var AlreadyClaimed = errors.New("the user has already claimed coins in the event")
+var NoCoinsLeft = errors.New("no coins are left in the event")
+var EventPaused = errors.New("the event is paused")
+var EventEnded = errors.New("the event has ended")
 
-func (db *DB) ScheduleEvent(coins int, start time.Time, duration Duration, surprise bool) error {
-	_, err := db.Exec(db.Rebind(`
+// Puts a new event into the queue, returns its id.
+func (db *DB) ScheduleEvent(coins Coins, start time.Time, duration Duration, surprise bool, opts EventOptions) (int, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	id, err := scheduleEvent(tx, coins, start, duration, surprise, opts)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// Schedules the occurrence of the recurrence starting at the given time,
+// returns the id of the event.
+func (db *DB) ScheduleRecurrence(r *Recurrence, start time.Time, opts EventOptions) (int, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	id, err := scheduleEvent(tx, r.Coins, start, r.Duration, r.Surprise, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(tx.Rebind(
+		"update recurrence set last_event_id = ? where id = ?"),
+		id, r.ID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update the recurrence: %v", err)
+	}
+	return id, tx.Commit()
+}
+
+func scheduleEvent(tx *sqlx.Tx, coins Coins, start time.Time, duration Duration, surprise bool, opts EventOptions) (int, error) {
+	var id int
+	err := tx.Get(&id, tx.Rebind(`
 		insert into event (
-			coins, duration, scheduled_at, surprise
-		) values (?, ?, ?, ?)`),
-		coins, duration, start, surprise,
+			coins, duration, scheduled_at, surprise, payout_mode, distribution,
+			eligibility, late_pool, countdown
+		) values (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		returning id`),
+		coins, duration, start, surprise, opts.PayoutMode, opts.Distribution,
+		opts.Eligibility, opts.LatePool, opts.Countdown,
 	)
-	return err
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert event: %v", err)
+	}
+	return id, nil
 }
 
-func (db *DB) StartNewEvent(coins int, duration Duration) error {
+// Adds the coins put aside by the rollover policy to the event.
+func takeRollover(tx *sqlx.Tx, eventID int) (Coins, error) {
+	var balance Coins
+	err := tx.Get(&balance, "select coalesce(sum(coins), 0) from treasury_ledger")
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the rollover balance: %v", err)
+	}
+	if balance <= 0 {
+		return 0, nil
+	}
+
+	_, err = tx.Exec(tx.Rebind(
+		"insert into treasury_ledger (event_id, coins) values (?, ?)"),
+		eventID, -balance,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to take the rollover: %v", err)
+	}
+
+	_, err = tx.Exec(tx.Rebind(`
+		update event
+		set coins = coins + ?, rollover_in = rollover_in + ?
+		where id = ?`),
+		balance, balance, eventID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add the rollover to the event: %v", err)
+	}
+	return balance, nil
+}
+
+func (db *DB) StartNewEvent(coins Coins, duration Duration, opts EventOptions, caps *Caps) error {
 	tx, err := db.Beginx()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %v", err)
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec(tx.Rebind(`
+	var id int
+	err = tx.Get(&id, tx.Rebind(`
 		insert into event (
-			coins, duration, started_at, surprise
-		) values (?, ?, ?, ?)`),
-		coins, duration, time.Now(), true,
+			coins, duration, started_at, surprise, payout_mode, distribution,
+			eligibility, late_pool, countdown
+		) values (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		returning id`),
+		coins, duration, time.Now(), true, opts.PayoutMode, opts.Distribution,
+		opts.Eligibility, opts.LatePool, opts.Countdown,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to insert event: %v", err)
 	}
 
 	var event Event
-	if err = tx.Get(&event, "SELECT * FROM event WHERE ended_at IS NULL"); err != nil {
+	if err = tx.Get(&event, tx.Rebind("SELECT * FROM event WHERE id = ?"), id); err != nil {
 		return fmt.Errorf("event inserted, but could not be found immediatly after: %v", err)
 	}
 
-	if err := event.addParticipants(tx); err != nil {
+	rollover, err := takeRollover(tx, event.ID)
+	if err != nil {
+		return err
+	}
+	event.Coins += rollover
+	event.RolloverIn += rollover
+
+	if err := event.addParticipants(tx, caps); err != nil {
 		return fmt.Errorf("failed to add participants: %v", err)
 	}
 
@@ -63,30 +154,91 @@ func (db *DB) StartNewEvent(coins int, duration Duration) error {
 	return nil
 }
 
-func (e *Event) addParticipants(tx *sqlx.Tx) error {
-	var users []TempUser
-	err := tx.Select(&users, "SELECT id, username FROM botuser WHERE NOT banned AND enlisted")
+// Snapshots the users who pass the eligibility rules of the event and the
+// caps, and splits the coins between them.
+func (e *Event) addParticipants(tx *sqlx.Tx, caps *Caps) error {
+	rules, err := ParseRules(e.Eligibility)
+	if err != nil {
+		return err
+	}
+	if caps != nil {
+		rules = append(rules, caps)
+	}
+
+	var candidates []TempUser
+	// the weight is one plus the number of messages in the last 30 days
+	err = tx.Select(&candidates, `
+		SELECT id, username, joined_at, first_seen, 1 + (
+			SELECT coalesce(sum(messages), 0) FROM activity
+			WHERE user_id = botuser.id AND day > current_date - 30
+		) AS weight
+		FROM botuser
+		WHERE NOT banned AND enlisted
+		ORDER BY id`)
 	if err != nil {
 		return fmt.Errorf("failed to select eligible users for coin distribution: %v", err)
 	}
 
-	if len(users) == 0 {
-		return nil
+	var users []TempUser
+	for i := range candidates {
+		user := &candidates[i]
+		reason, err := checkEligibility(tx, e, user, rules)
+		if err != nil {
+			return fmt.Errorf("failed to check eligibility: %v", err)
+		}
+		if reason == "" {
+			users = append(users, *user)
+			continue
+		}
+
+		_, err = tx.Exec(tx.Rebind(`
+			insert into exclusion (
+				event_id, user_id, username, reason
+			) values (?, ?, ?, ?)`),
+			e.ID, user.ID, user.UserName, reason,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record an exclusion: %v", err)
+		}
+	}
+
+	var seed int64
+	var secret, commitment sql.NullString
+	if e.IsRaffle() {
+		s, err := newRaffleSecret()
+		if err != nil {
+			return err
+		}
+		if seed, err = raffleSeed(s); err != nil {
+			return err
+		}
+		secret = sql.NullString{String: s, Valid: true}
+		commitment = sql.NullString{String: raffleCommitment(s), Valid: true}
+	} else if seed, err = newSeed(); err != nil {
+		return err
 	}
 
-	coinsPerUser := e.Coins / len(users)
-	volatility := 0
-	if e.Coins%len(users) != 0 {
-		volatility = 1
+	_, err = tx.Exec(tx.Rebind(`
+		update event set seed = ?, secret = ?, commitment = ?
+		where id = ?`),
+		seed, secret, commitment, e.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save the seed: %v", err)
 	}
+	e.Seed = sql.NullInt64{Int64: seed, Valid: true}
+	e.Secret, e.Commitment = secret, commitment
 
-	for _, user := range users {
-		coins := coinsPerUser + rand.Intn(volatility+1)
+	shares, err := e.split(users)
+	if err != nil {
+		return err
+	}
+	for i, user := range users {
 		_, err := tx.Exec(tx.Rebind(`
 			insert into participant (
-				event_id, user_id, username, coins
-			) values (?, ?, ?, ?)`),
-			e.ID, user.ID, user.UserName, coins,
+				event_id, user_id, username, coins, weight
+			) values (?, ?, ?, ?, ?)`),
+			e.ID, user.ID, user.UserName, shares[i], user.Weight,
 		)
 		if err != nil {
 			return fmt.Errorf("failed to add user to event participants: %v", err)
@@ -95,10 +247,115 @@ func (e *Event) addParticipants(tx *sqlx.Tx) error {
 	return nil
 }
 
-func (db *DB) CoinsClaimed(e *Event) (int, error) {
-	var coins int
+// Adds a user who has joined the group during the event to its participants,
+// if the user passes the eligibility rules. The user gets an average share of
+// the snapshot, or what is left in the late pool if less. Returns the coins
+// the user can claim, `NoCoinsLeft` if the late pool has run out, `EventEnded`
+// if the event has ended meanwhile, or zero if the user is not eligible or on
+// the list already.
+func (db *DB) AddLateParticipant(e *Event, user *User, caps *Caps) (Coins, error) {
+	rules, err := ParseRules(e.Eligibility)
+	if err != nil {
+		return 0, err
+	}
+	if caps != nil {
+		rules = append(rules, caps)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// locking the event serializes the late joins, so the pool is never overdrawn
+	var share, left Coins
+	var ended bool
+	err = tx.QueryRowx(tx.Rebind(`
+		select
+			(event.coins - ?) / greatest((
+				select count(*) from participant
+				where event_id = event.id and not late
+			), 1),
+			? - (
+				select coalesce(sum(coins), 0) from participant
+				where event_id = event.id and late
+			),
+			ended_at is not null
+		from event
+		where id = ?
+		for update`),
+		e.LateReserve(), e.LateReserve(), e.ID,
+	).Scan(&share, &left, &ended)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the late pool: %v", err)
+	}
+	if ended {
+		return 0, EventEnded
+	}
+
+	var known bool
+	err = tx.Get(&known, tx.Rebind(`
+		select
+			exists(select 1 from participant where event_id = ? and user_id = ?)
+			or exists(select 1 from exclusion where event_id = ? and user_id = ?)`),
+		e.ID, user.ID, e.ID, user.ID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check the participants: %v", err)
+	}
+	if known || user.Banned {
+		return 0, nil
+	}
+
+	candidate := TempUser{
+		ID: user.ID, UserName: user.UserName,
+		JoinedAt: user.JoinedAt, FirstSeen: user.FirstSeen,
+	}
+	reason, err := checkEligibility(tx, e, &candidate, rules)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check eligibility: %v", err)
+	}
+	if reason != "" {
+		_, err = tx.Exec(tx.Rebind(`
+			insert into exclusion (
+				event_id, user_id, username, reason
+			) values (?, ?, ?, ?)`),
+			e.ID, user.ID, user.UserName, reason,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to record an exclusion: %v", err)
+		}
+		return 0, tx.Commit()
+	}
+
+	if left <= 0 {
+		return 0, NoCoinsLeft
+	}
+	if share > left {
+		share = left
+	}
+
+	_, err = tx.Exec(tx.Rebind(`
+		insert into participant (
+			event_id, user_id, username, coins, late
+		) values (?, ?, ?, ?, true)`),
+		e.ID, user.ID, user.UserName, share,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add the late participant: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit the late participant: %v", err)
+	}
+	return share, nil
+}
+
+func (db *DB) CoinsClaimed(e *Event) (Coins, error) {
+	var coins Coins
 	err := db.Get(&coins, db.Rebind(`
-		select sum(coins)
+		select coalesce(sum(coins), 0)
 		from participant
 		where event_id = ? and claimed_at is not null`),
 		e.ID,
@@ -109,7 +366,7 @@ func (db *DB) CoinsClaimed(e *Event) (int, error) {
 	return coins, nil
 }
 
-func (db *DB) CoinsUnclaimed(e *Event) (int, error) {
+func (db *DB) CoinsUnclaimed(e *Event) (Coins, error) {
 	claimed, err := db.CoinsClaimed(e)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count unclaimed coins: %v", err)
@@ -122,7 +379,7 @@ func (db *DB) ClaimersLeft(e *Event) (int, error) {
 	err := db.Get(&claimers, db.Rebind(`
 		select count(user_id)
 		from participant
-		where event_id = ? and claimed_at is null`),
+		where event_id = ? and claimed_at is null and coins > 0`),
 		e.ID,
 	)
 	if err != nil {
@@ -131,7 +388,7 @@ func (db *DB) ClaimersLeft(e *Event) (int, error) {
 	return claimers, nil
 }
 
-func (db *DB) StartEvent(e *Event) error {
+func (db *DB) StartEvent(e *Event, caps *Caps) error {
 	if e.StartedAt.Valid {
 		return errors.New("already started")
 	}
@@ -151,7 +408,14 @@ func (db *DB) StartEvent(e *Event) error {
 		return fmt.Errorf("failed to update event status: %v", err)
 	}
 
-	if err := e.addParticipants(tx); err != nil {
+	rollover, err := takeRollover(tx, e.ID)
+	if err != nil {
+		return err
+	}
+	e.Coins += rollover
+	e.RolloverIn += rollover
+
+	if err := e.addParticipants(tx, caps); err != nil {
 		return fmt.Errorf("failed to add participants: %v", err)
 	}
 
@@ -163,25 +427,95 @@ func (db *DB) StartEvent(e *Event) error {
 	return nil
 }
 
-func (db *DB) EndEvent(e *Event) error {
+// Ends the event. With `rollover`, the coins left unclaimed are put aside
+// for the next event which starts.
+func (db *DB) EndEvent(e *Event, rollover bool) error {
 	if e.EndedAt.Valid {
 		return errors.New("already ended")
 	}
 	t := NewNullTime(time.Now())
-	_, err := db.Exec(
-		db.Rebind("update event set ended_at = ? where id = ?"),
-		t, e.ID,
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// the claims lock the event too, so none can commit after the leftover
+	// is counted
+	var ended bool
+	err = tx.Get(&ended, tx.Rebind(
+		"select ended_at is not null from event where id = ? for update"),
+		e.ID,
 	)
-	if err == nil {
-		e.EndedAt = t
+	if err != nil {
+		return fmt.Errorf("failed to lock the event: %v", err)
 	}
-	return err
+	if ended {
+		return errors.New("already ended")
+	}
+
+	var leftover Coins
+	if e.StartedAt.Valid && rollover {
+		err = tx.Get(&leftover, tx.Rebind(`
+			select ? - coalesce(sum(coins), 0)
+			from participant
+			where event_id = ? and claimed_at is not null`),
+			e.Coins, e.ID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to count the leftover: %v", err)
+		}
+	}
+
+	if leftover > 0 {
+		_, err = tx.Exec(tx.Rebind(
+			"insert into treasury_ledger (event_id, coins) values (?, ?)"),
+			e.ID, leftover,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to put the leftover aside: %v", err)
+		}
+	} else {
+		leftover = 0
+	}
+
+	// the secret of a raffle is revealed along with the end
+	_, err = tx.Exec(tx.Rebind(`
+		update event
+		set ended_at = ?, reveal = secret, rolled_over = ?
+		where id = ?`),
+		t, leftover, e.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit the end: %v", err)
+	}
+	e.EndedAt = t
+	e.Reveal = e.Secret
+	e.RolledOver = leftover
+	return nil
+}
+
+// Returns the coins put aside by the rollover policy for the next event.
+func (db *DB) RolloverBalance() (Coins, error) {
+	var balance Coins
+	err := db.Get(&balance, "select coalesce(sum(coins), 0) from treasury_ledger")
+	return balance, err
 }
 
 func (db *DB) GetCurrentEvent() *Event {
 	var event Event
 
-	err := db.Get(&event, "SELECT * FROM event WHERE ended_at IS NULL")
+	// the started event, or the one which starts first
+	err := db.Get(&event, `
+		SELECT * FROM event
+		WHERE ended_at IS NULL
+		ORDER BY started_at IS NULL, coalesce(started_at, scheduled_at)
+		LIMIT 1`)
 
 	if err == sql.ErrNoRows {
 		return nil
@@ -195,6 +529,177 @@ func (db *DB) GetCurrentEvent() *Event {
 	return &event
 }
 
+// Saves the coins, start time, duration and surprise flag of a scheduled
+// event. Returns false if the event has started or ended meanwhile.
+func (db *DB) EditEvent(e *Event) (bool, error) {
+	result, err := db.Exec(db.Rebind(`
+		update event
+		set coins = ?, scheduled_at = ?, duration = ?, surprise = ?
+		where id = ? and started_at is null and ended_at is null`),
+		e.Coins, e.ScheduledAt, e.Duration, e.Surprise, e.ID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update event: %v", err)
+	}
+	changed, err := result.RowsAffected()
+	return changed > 0, err
+}
+
+// Changes the duration of the started event. Returns false if the event
+// has ended meanwhile.
+func (db *DB) SetEventDuration(e *Event, duration Duration) (bool, error) {
+	result, err := db.Exec(db.Rebind(`
+		update event set duration = ?
+		where id = ? and started_at is not null and ended_at is null`),
+		duration, e.ID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update event: %v", err)
+	}
+	changed, err := result.RowsAffected()
+	if changed > 0 {
+		e.Duration = duration
+	}
+	return changed > 0, err
+}
+
+// Pauses the claims of the started event. Returns false if the event is
+// paused already or has ended meanwhile.
+func (db *DB) PauseEvent(e *Event) (bool, error) {
+	t := NewNullTime(time.Now())
+	result, err := db.Exec(db.Rebind(`
+		update event set paused_at = ?
+		where
+			id = ?
+			and started_at is not null
+			and ended_at is null
+			and paused_at is null`),
+		t, e.ID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to pause event: %v", err)
+	}
+	changed, err := result.RowsAffected()
+	if changed > 0 {
+		e.PausedAt = t
+	}
+	return changed > 0, err
+}
+
+// Resumes the claims of the paused event, and extends its duration by the
+// time it has been paused. Returns false if the event is not paused.
+func (db *DB) ResumeEvent(e *Event) (bool, error) {
+	var duration Duration
+	err := db.Get(&duration, db.Rebind(`
+		update event
+		set
+			duration = duration + (extract(epoch from now() - paused_at) * 1e9)::bigint,
+			paused_at = null,
+			resumed_at = now()
+		where id = ? and ended_at is null and paused_at is not null
+		returning duration`),
+		e.ID,
+	)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to resume event: %v", err)
+	}
+	e.Duration = duration
+	e.PausedAt = NullTime{}
+	e.ResumedAt = NewNullTime(time.Now())
+	return true, nil
+}
+
+// Remembers the message which shows the status of the event. A new message
+// is not pinned yet.
+func (db *DB) SetStatusMessage(e *Event, messageID int) error {
+	_, err := db.Exec(db.Rebind(
+		"update event set status_message_id = ?, status_pinned = false where id = ?"),
+		messageID, e.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save the status message: %v", err)
+	}
+	e.StatusMessageID = sql.NullInt64{Int64: int64(messageID), Valid: true}
+	e.StatusPinned = false
+	return nil
+}
+
+// Forgets the status message of the event, the next update posts another.
+func (db *DB) ClearStatusMessage(e *Event) error {
+	_, err := db.Exec(db.Rebind(
+		"update event set status_message_id = null, status_pinned = false where id = ?"),
+		e.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to forget the status message: %v", err)
+	}
+	e.StatusMessageID = sql.NullInt64{}
+	e.StatusPinned = false
+	return nil
+}
+
+// Remembers whether the bot has pinned the status message of the event.
+func (db *DB) SetStatusPinned(e *Event, pinned bool) error {
+	_, err := db.Exec(db.Rebind(
+		"update event set status_pinned = ? where id = ?"),
+		pinned, e.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save the status pin: %v", err)
+	}
+	e.StatusPinned = pinned
+	return nil
+}
+
+// Returns the events which have not ended, the started one first, then the
+// queue of the scheduled ones by start time.
+func (db *DB) GetOpenEvents() ([]Event, error) {
+	var events []Event
+	err := db.Select(&events, `
+		select * from event
+		where ended_at is null
+		order by started_at is null, coalesce(started_at, scheduled_at)`)
+	return events, err
+}
+
+// Returns the first event which has not ended and overlaps the given time
+// range, not counting the `except` event. Returns nil if none.
+func (db *DB) GetOverlappingEvent(start time.Time, duration Duration, except int) (*Event, error) {
+	var event Event
+	err := db.Get(&event, db.Rebind(`
+		select * from event
+		where
+			ended_at is null
+			and id != ?
+			and coalesce(started_at, scheduled_at) < ?
+			and ? < coalesce(started_at, scheduled_at) + duration / 1000 * interval '1 microsecond'
+		order by coalesce(started_at, scheduled_at)
+		limit 1`),
+		except, start.Add(duration.Duration), start,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look for overlapping events: %v", err)
+	}
+	return &event, nil
+}
+
+func (db *DB) GetEvent(id int) *Event {
+	var event Event
+
+	err := db.Get(&event, db.Rebind("SELECT * FROM event WHERE id = ?"), id)
+	if err != nil {
+		return nil
+	}
+
+	return &event
+}
+
 func (db *DB) GetLastEvent() *Event {
 	var event Event
 
@@ -302,20 +807,244 @@ func (db *DB) GetWinners(eventID int) ([]Participant, error) {
 	return winners, nil
 }
 
-func (db *DB) ClaimCoins(user *User, event *Event) error {
-	_, err := db.Exec(db.Rebind(`
+// Marks the coins of the user in the event as claimed to the given address
+// and puts a pending payout for them into the ledger. The claim is cut to
+// what is left in the pool and to the caps. The late reserve is a pool of
+// its own, only the late joiners claim from it. Returns the coins claimed,
+// `AlreadyClaimed` if the user has claimed the coins before or is not a
+// participant, `NoCoinsLeft` if the pool has run out, `EventEnded` if the
+// event has ended meanwhile, or `CapReached`.
+func (db *DB) ClaimCoins(user *User, event *Event, address string, caps *Caps) (Coins, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// locking the event serializes the claims, so the pool is never overdrawn;
+	// the late joiners claim from the late reserve, the others from the rest
+	var left Coins
+	var paused, ended bool
+	err = tx.QueryRowx(tx.Rebind(`
+		select
+			case when coalesce((
+				select late from participant
+				where event_id = event.id and user_id = ?
+			), false)
+			then ? - (
+				select coalesce(sum(coins), 0)
+				from participant
+				where event_id = event.id and claimed_at is not null and late
+			)
+			else event.coins - ? - (
+				select coalesce(sum(coins), 0)
+				from participant
+				where event_id = event.id and claimed_at is not null and not late
+			)
+			end,
+			paused_at is not null,
+			ended_at is not null
+		from event
+		where id = ?
+		for update`),
+		user.ID, event.LateReserve(), event.LateReserve(), event.ID,
+	).Scan(&left, &paused, &ended)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the coins left: %v", err)
+	}
+	if ended {
+		return 0, EventEnded
+	}
+	if paused {
+		return 0, EventPaused
+	}
+	if left <= 0 {
+		return 0, NoCoinsLeft
+	}
+
+	allowance, err := caps.allowance(tx, user.ID)
+	if err != nil {
+		return 0, err
+	}
+	if allowance >= 0 && allowance < left {
+		left = allowance
+	}
+
+	var coins Coins
+	err = tx.Get(&coins, tx.Rebind(`
 		update participant
-		set claimed_at = now()
+		set claimed_at = now(), address = ?, coins = least(coins, ?)
 		where
 			user_id = ?
-			and event_id = ?`),
-		user.ID, event.ID,
+			and event_id = ?
+			and claimed_at is null
+		returning coins`),
+		address, left, user.ID, event.ID,
+	)
+	if err == sql.ErrNoRows {
+		return 0, AlreadyClaimed
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark the coins as claimed: %v", err)
+	}
+
+	_, err = tx.Exec(tx.Rebind(`
+		insert into payout (
+			event_id, user_id, address, coins, batch
+		) values (?, ?, ?, ?, ?)`),
+		event.ID, user.ID, address, coins, event.PayoutMode == PayoutModeBatch,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to add the payout: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit the claim: %v", err)
+	}
+	return coins, nil
+}
+
+// Returns the payouts which have not left the wallet yet, oldest first.
+// Payouts which have failed `maxAttempts` times are left out, unless
+// `maxAttempts` is zero. So are the batch payouts of events which have not
+// ended yet.
+func (db *DB) GetPayoutsToSend(maxAttempts int) ([]Payout, error) {
+	var payouts []Payout
+	err := db.Select(&payouts, db.Rebind(`
+		select payout.* from payout
+		join event on event.id = payout.event_id
+		where
+			payout.status in (?, ?)
+			and (? = 0 or payout.attempts < ?)
+			and (not payout.batch or event.ended_at is not null)
+		order by payout.id`),
+		PayoutPending, PayoutFailed, maxAttempts, maxAttempts,
+	)
+	return payouts, err
+}
+
+// Returns the payouts which have been sent, but not confirmed yet.
+func (db *DB) GetPayoutsToConfirm() ([]Payout, error) {
+	var payouts []Payout
+	err := db.Select(&payouts, db.Rebind(
+		"select * from payout where status = ? order by id"),
+		PayoutSent,
+	)
+	return payouts, err
+}
+
+// Returns all the payouts which are not confirmed yet.
+func (db *DB) GetUnfinishedPayouts() ([]Payout, error) {
+	var payouts []Payout
+	err := db.Select(&payouts, db.Rebind(
+		"select * from payout where status != ? order by id"),
+		PayoutConfirmed,
+	)
+	return payouts, err
+}
+
+// Returns the payouts whose transaction may or may not have been sent.
+func (db *DB) GetPayoutsSending() ([]Payout, error) {
+	var payouts []Payout
+	err := db.Select(&payouts, db.Rebind(
+		"select * from payout where status = ? order by id"),
+		PayoutSending,
+	)
+	return payouts, err
+}
+
+// Records the transaction which is about to send the payouts.
+func (db *DB) PayoutsSending(payouts []*Payout, txid string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, p := range payouts {
+		err := tx.Get(p, tx.Rebind(`
+			update payout
+			set status = ?, txid = ?, updated_at = now()
+			where id = ?
+			returning *`),
+			PayoutSending, txid, p.ID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update payout %d: %v", p.ID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Marks the payouts as sent by the transaction, and links the transaction
+// to the participants the payouts are for.
+func (db *DB) PayoutsSent(payouts []*Payout, txid string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, p := range payouts {
+		err := tx.Get(p, tx.Rebind(`
+			update payout
+			set
+				status = ?,
+				txid = ?,
+				attempts = attempts + 1,
+				updated_at = now()
+			where id = ?
+			returning *`),
+			PayoutSent, txid, p.ID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update payout %d: %v", p.ID, err)
+		}
+
+		_, err = tx.Exec(tx.Rebind(`
+			update participant
+			set txid = ?
+			where event_id = ? and user_id = ?`),
+			txid, p.EventID, p.UserID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to link payout %d to the participant: %v", p.ID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) PayoutFailed(p *Payout, cause error) error {
+	err := db.Get(p, db.Rebind(`
+		update payout
+		set
+			status = ?,
+			txid = null,
+			last_error = ?,
+			attempts = attempts + 1,
+			updated_at = now()
+		where id = ?
+		returning *`),
+		PayoutFailed, cause.Error(), p.ID,
+	)
+	return err
+}
+
+func (db *DB) PayoutConfirmed(p *Payout) error {
+	err := db.Get(p, db.Rebind(`
+		update payout
+		set status = ?, updated_at = now()
+		where id = ?
+		returning *`),
+		PayoutConfirmed, p.ID,
 	)
 	return err
 }
 
-func (db *DB) GetCoinsToClaim(user *User, event *Event) (int, error) {
-	var coins int
+func (db *DB) GetCoinsToClaim(user *User, event *Event) (Coins, error) {
+	var coins Coins
 	var claimedAt NullTime
 	err := db.QueryRowx(db.Rebind(`
 		select coins, claimed_at
@@ -341,6 +1070,379 @@ func (db *DB) GetCoinsToClaim(user *User, event *Event) (int, error) {
 	return coins, nil
 }
 
+// Returns the address saved by the user, or an empty string if none.
+func (db *DB) GetSavedAddress(user *User) (string, error) {
+	var address string
+	err := db.Get(&address, db.Rebind(
+		"select address from saved_address where user_id = ?"),
+		user.ID,
+	)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return address, err
+}
+
+// Saves the address for the user, replacing the previous one. An empty
+// address clears it. The change is recorded in the audit log.
+func (db *DB) SetSavedAddress(user *User, address string) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var previous sql.NullString
+	err = tx.Get(&previous, tx.Rebind(
+		"select address from saved_address where user_id = ? for update"),
+		user.ID,
+	)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to get the saved address: %v", err)
+	}
+
+	next := sql.NullString{String: address, Valid: address != ""}
+	if previous == next {
+		return nil
+	}
+
+	if next.Valid {
+		_, err = tx.Exec(tx.Rebind(`
+			insert into saved_address (user_id, address) values (?, ?)
+			on conflict (user_id) do update
+			set address = excluded.address, updated_at = now()`),
+			user.ID, address,
+		)
+	} else {
+		_, err = tx.Exec(tx.Rebind(
+			"delete from saved_address where user_id = ?"),
+			user.ID,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save the address: %v", err)
+	}
+
+	_, err = tx.Exec(tx.Rebind(`
+		insert into address_change (
+			user_id, old_address, new_address
+		) values (?, ?, ?)`),
+		user.ID, previous, next,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record the address change: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit the address change: %v", err)
+	}
+	return nil
+}
+
+// Returns the history of the saved addresses of the user, oldest first.
+func (db *DB) GetAddressChanges(user *User) ([]AddressChange, error) {
+	var changes []AddressChange
+	err := db.Select(&changes, db.Rebind(
+		"select * from address_change where user_id = ? order by id"),
+		user.ID,
+	)
+	return changes, err
+}
+
+// Returns the number of coins the bot owes: the claims which have not been
+// sent yet, the unclaimed coins of the events which have not ended, and the
+// coins put aside for the next event. The `except` event, if given, is left
+// out.
+func (db *DB) CoinsOwed(except *Event) (Coins, error) {
+	exceptID := 0
+	if except != nil {
+		exceptID = except.ID
+	}
+
+	var owed Coins
+	err := db.Get(&owed, db.Rebind(`
+		select
+			(select coalesce(sum(coins), 0)
+				from payout
+				where status in (?, ?))
+			+ (select coalesce(sum(coins), 0)
+				from event
+				where ended_at is null and id != ?)
+			- (select coalesce(sum(participant.coins), 0)
+				from participant
+				join event on event.id = participant.event_id
+				where
+					event.ended_at is null
+					and event.id != ?
+					and participant.claimed_at is not null)
+			+ (select coalesce(sum(coins), 0)
+				from treasury_ledger)`),
+		PayoutPending, PayoutFailed, exceptID, exceptID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count owed coins: %v", err)
+	}
+	return owed, nil
+}
+
+// Returns the recurrences, the paused ones only if asked for.
+func (db *DB) GetRecurrences(paused bool) ([]Recurrence, error) {
+	var recurrences []Recurrence
+	err := db.Select(&recurrences, db.Rebind(`
+		select recurrence.*, event.scheduled_at as last_scheduled_at
+		from recurrence
+		left join event on event.id = recurrence.last_event_id
+		where ? or not recurrence.paused
+		order by recurrence.id`),
+		paused,
+	)
+	return recurrences, err
+}
+
+func (db *DB) AddRecurrence(r *Recurrence) error {
+	return db.Get(&r.ID, db.Rebind(`
+		insert into recurrence (
+			weekday, time_of_day, coins, duration, surprise, options
+		) values (?, ?, ?, ?, ?, ?)
+		returning id`),
+		r.Weekday, r.TimeOfDay, r.Coins, r.Duration, r.Surprise, r.Options,
+	)
+}
+
+// Pauses or resumes the recurrence. Returns false if there is no such
+// recurrence.
+func (db *DB) PauseRecurrence(id int, paused bool) (bool, error) {
+	result, err := db.Exec(db.Rebind(
+		"update recurrence set paused = ? where id = ?"),
+		paused, id,
+	)
+	if err != nil {
+		return false, err
+	}
+	changed, err := result.RowsAffected()
+	return changed > 0, err
+}
+
+// Deletes the recurrence, the events it has scheduled are kept. Returns
+// false if there is no such recurrence.
+func (db *DB) DeleteRecurrence(id int) (bool, error) {
+	result, err := db.Exec(db.Rebind("delete from recurrence where id = ?"), id)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// Returns the users left out of the event by the eligibility rules.
+func (db *DB) GetExclusions(eventID int) ([]Exclusion, error) {
+	var exclusions []Exclusion
+	err := db.Select(&exclusions, db.Rebind(
+		"select * from exclusion where event_id = ? order by user_id"),
+		eventID,
+	)
+	return exclusions, err
+}
+
+// Records that the user has joined the group just now.
+func (db *DB) UserJoined(u *User) error {
+	t := NewNullTime(time.Now())
+	_, err := db.Exec(db.Rebind("update botuser set joined_at = ? where id = ?"), t, u.ID)
+	if err == nil {
+		u.JoinedAt = t
+	}
+	return err
+}
+
+// Counts a message of the user in the group, and updates when the user has
+// been seen.
+func (db *DB) RecordMessage(u *User) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(tx.Rebind(`
+		insert into activity (user_id, day, messages)
+		values (?, current_date, 1)
+		on conflict (user_id, day)
+		do update set messages = activity.messages + 1`),
+		u.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to count the message: %v", err)
+	}
+
+	_, err = tx.Exec(tx.Rebind(`
+		update botuser
+		set first_seen = coalesce(first_seen, now()), last_seen = now()
+		where id = ?`),
+		u.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update when the user was seen: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// Returns the number of messages the user wrote in the group per day since
+// the given time, latest first. Days without messages are left out.
+func (db *DB) GetActivity(u *User, since time.Time) ([]DailyActivity, error) {
+	var days []DailyActivity
+	err := db.Select(&days, db.Rebind(`
+		select day, messages from activity
+		where user_id = ? and day >= ?::date
+		order by day desc`),
+		u.ID, since,
+	)
+	return days, err
+}
+
+// Returns the number of messages the user wrote in the group since the
+// given time, all of them if the time is zero.
+func (db *DB) CountMessages(u *User, since time.Time) (int, error) {
+	var messages int
+	err := db.Get(&messages, db.Rebind(`
+		select coalesce(sum(messages), 0) from activity
+		where user_id = ? and day >= ?::date`),
+		u.ID, since,
+	)
+	return messages, err
+}
+
+// Returns the users who wrote the most messages since the given time, along
+// with the numbers of their messages.
+func (db *DB) GetMostActive(since time.Time, limit int) ([]User, []int, error) {
+	var rows []struct {
+		User
+		Messages int `db:"messages"`
+	}
+	err := db.Select(&rows, db.Rebind(`
+		select botuser.*, sum(activity.messages) as messages
+		from activity
+		join botuser on botuser.id = activity.user_id
+		where activity.day >= ?::date
+		group by botuser.id
+		order by messages desc, botuser.id
+		limit ?`),
+		since, limit,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	users := make([]User, len(rows))
+	messages := make([]int, len(rows))
+	for i, row := range rows {
+		users[i], messages[i] = row.User, row.Messages
+	}
+	return users, messages, nil
+}
+
+// Returns the quiz questions of the event in the order they are asked.
+func (db *DB) GetQuestions(eventID int) ([]QuizQuestion, error) {
+	var questions []QuizQuestion
+	err := db.Select(&questions, db.Rebind(
+		"select * from quiz_question where event_id = ? order by id"),
+		eventID,
+	)
+	return questions, err
+}
+
+func (db *DB) AddQuestion(eventID int, question string, answers []string) error {
+	_, err := db.Exec(db.Rebind(`
+		insert into quiz_question (
+			event_id, question, answers
+		) values (?, ?, ?)`),
+		eventID, question, strings.Join(answers, "|"),
+	)
+	return err
+}
+
+// Removes the question if its event has not ended. Returns false if there is
+// no such question.
+func (db *DB) RemoveQuestion(id int) (bool, error) {
+	result, err := db.Exec(db.Rebind(`
+		delete from quiz_question
+		where
+			id = ?
+			and event_id in (select id from event where ended_at is null)`),
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
+
+// Returns the participant row of the user in the event, or
+// `NotParticipating`.
+func (db *DB) GetParticipant(user *User, event *Event) (*Participant, error) {
+	var p Participant
+	err := db.Get(&p, db.Rebind(
+		"select * from participant where user_id = ? and event_id = ?"),
+		user.ID, event.ID,
+	)
+	if err == sql.ErrNoRows {
+		return nil, NotParticipating
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Remembers that the current question has been asked, so that the next
+// message of the user is taken as the answer.
+func (db *DB) QuizAsked(p *Participant) error {
+	_, err := db.Exec(db.Rebind(`
+		update participant set quiz_asked = true
+		where user_id = ? and event_id = ?`),
+		p.UserID, p.EventID,
+	)
+	if err == nil {
+		p.QuizAsked = true
+	}
+	return err
+}
+
+// Records an answer to the current question: moves on to the next question
+// if the answer is correct, counts the attempt otherwise.
+func (db *DB) QuizAnswered(p *Participant, correct bool) error {
+	progress, attempts := p.QuizProgress, p.QuizAttempts
+	if correct {
+		progress++
+	} else {
+		attempts++
+	}
+
+	_, err := db.Exec(db.Rebind(`
+		update participant
+		set quiz_progress = ?, quiz_attempts = ?, quiz_asked = false
+		where user_id = ? and event_id = ?`),
+		progress, attempts, p.UserID, p.EventID,
+	)
+	if err == nil {
+		p.QuizProgress, p.QuizAttempts, p.QuizAsked = progress, attempts, false
+	}
+	return err
+}
+
+// Returns how much of the caps the user has used up, counting the wins in
+// the given window.
+func (db *DB) GetCapUsage(user *User, window time.Duration) (*CapUsage, error) {
+	return capUsage(db.DB, user.ID, window)
+}
+
+func (db *DB) GetAdmins() ([]User, error) {
+	var users []User
+	err := db.Select(&users, "select * from botuser where admin order by username")
+	return users, err
+}
+
 func (db *DB) GetUserCount(banned bool) (int, error) {
 	var count int
 
@@ -0,0 +1,253 @@
+package skyaway
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Decides how the coins of an event are split between the participants.
+type DistributionStrategy interface {
+	// Returns the share of each user, the users are ordered by id. The rng
+	// is seeded with the event seed, so that the split can be re-derived.
+	Split(coins Coins, users []TempUser, rng *rand.Rand) []Coins
+	// Returns the spec the strategy is parsed from.
+	String() string
+}
+
+// Parses a strategy spec: "equal", "fcfs:AMOUNT", "weighted", "random:N" or
+// "raffle:N". An empty spec means "equal".
+func ParseDistribution(spec string) (DistributionStrategy, error) {
+	kv := strings.SplitN(spec, ":", 2)
+	name, arg := kv[0], ""
+	if len(kv) == 2 {
+		arg = kv[1]
+	}
+
+	switch name {
+	case "", "equal":
+		return EqualSplit{}, nil
+	case "weighted":
+		return WeightedSplit{}, nil
+	case "fcfs":
+		amount, err := ParseCoins(arg)
+		if err != nil || amount <= 0 {
+			return nil, fmt.Errorf("fcfs needs a positive amount per claimer, e.g. fcfs:0.5")
+		}
+		return FirstComeSplit{amount}, nil
+	case "random", "raffle":
+		winners, err := strconv.Atoi(arg)
+		if err != nil || winners <= 0 {
+			return nil, fmt.Errorf("%s needs a positive number of winners, e.g. %s:10", name, name)
+		}
+		if name == "raffle" {
+			return RaffleSplit{RandomSplit{winners}}, nil
+		}
+		return RandomSplit{winners}, nil
+	default:
+		return nil, fmt.Errorf("unknown distribution strategy '%s'", name)
+	}
+}
+
+// Splits the coins equally between all the users.
+type EqualSplit struct{}
+
+func (EqualSplit) Split(coins Coins, users []TempUser, rng *rand.Rand) []Coins {
+	return splitCoins(coins, len(users), rng)
+}
+
+func (EqualSplit) String() string {
+	return "equal"
+}
+
+// Gives a fixed amount to each user who claims, until the pool runs out. The
+// claim which exhausts the pool gets only what is left.
+type FirstComeSplit struct {
+	Amount Coins
+}
+
+func (s FirstComeSplit) Split(coins Coins, users []TempUser, rng *rand.Rand) []Coins {
+	shares := make([]Coins, len(users))
+	for i := range shares {
+		shares[i] = s.Amount
+	}
+	return shares
+}
+
+func (s FirstComeSplit) String() string {
+	return "fcfs:" + s.Amount.String()
+}
+
+// Splits the coins in proportion to the activity of the users.
+type WeightedSplit struct{}
+
+func (WeightedSplit) Split(coins Coins, users []TempUser, rng *rand.Rand) []Coins {
+	if len(users) == 0 {
+		return nil
+	}
+
+	total := big.NewInt(0)
+	for _, user := range users {
+		total.Add(total, big.NewInt(user.Weight))
+	}
+	if total.Sign() == 0 {
+		return splitCoins(coins, len(users), rng)
+	}
+
+	shares := make([]Coins, len(users))
+	left := coins
+	for i, user := range users {
+		share := big.NewInt(int64(coins))
+		share.Mul(share, big.NewInt(user.Weight))
+		share.Quo(share, total)
+		shares[i] = Coins(share.Int64())
+		left -= shares[i]
+	}
+
+	// less than one droplet per user is left due to rounding down
+	for _, i := range rng.Perm(len(users))[:left] {
+		shares[i]++
+	}
+	return shares
+}
+
+func (WeightedSplit) String() string {
+	return "weighted"
+}
+
+// Draws a number of winners and splits the coins equally between them, the
+// other users get nothing.
+type RandomSplit struct {
+	Winners int
+}
+
+func (s RandomSplit) Split(coins Coins, users []TempUser, rng *rand.Rand) []Coins {
+	shares := make([]Coins, len(users))
+	winners := rng.Perm(len(users))
+	if len(winners) > s.Winners {
+		winners = winners[:s.Winners]
+	}
+
+	for i, share := range splitCoins(coins, len(winners), rng) {
+		shares[winners[i]] = share
+	}
+	return shares
+}
+
+func (s RandomSplit) String() string {
+	return fmt.Sprintf("random:%d", s.Winners)
+}
+
+// Returns a fresh seed for splitting the coins of an event.
+func newSeed() (int64, error) {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return 0, fmt.Errorf("failed to generate a seed: %v", err)
+	}
+	return int64(binary.LittleEndian.Uint64(b[:]) >> 1), nil
+}
+
+// Splits the coins between `n` users, so that the shares differ by one
+// droplet at most and add up to exactly `coins`. The extra droplets go to the
+// users picked by the rng.
+func splitCoins(coins Coins, n int, rng *rand.Rand) []Coins {
+	if n == 0 {
+		return nil
+	}
+
+	shares := make([]Coins, n)
+	base, remainder := coins/Coins(n), int(coins%Coins(n))
+	for i := range shares {
+		shares[i] = base
+	}
+
+	for _, i := range rng.Perm(n)[:remainder] {
+		shares[i]++
+	}
+	return shares
+}
+
+// Splits the coins of the event between the users with its strategy and
+// seed.
+func (e *Event) split(users []TempUser) ([]Coins, error) {
+	strategy, err := ParseDistribution(e.Distribution)
+	if err != nil {
+		return nil, err
+	}
+	rng := rand.New(rand.NewSource(e.Seed.Int64))
+	return strategy.Split(e.Coins-e.LateReserve(), users, rng), nil
+}
+
+// Picks the participants the coins were split between, in the order the
+// split goes over them: by telegram id, without the late joiners.
+func splitOrder(participants []Participant) []Participant {
+	var ordered []Participant
+	for _, p := range participants {
+		if !p.Late {
+			ordered = append(ordered, p)
+		}
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].UserID < ordered[j].UserID
+	})
+	return ordered
+}
+
+// Re-derives the split of the event from its seed and compares it with the
+// participants in the database. Returns a description of every mismatch.
+func (bot *Bot) VerifySplit(event *Event) ([]string, error) {
+	if !event.Seed.Valid {
+		return nil, fmt.Errorf("event %d has no seed, it has not started yet", event.ID)
+	}
+	if event.IsRaffle() && !event.EndedAt.Valid {
+		// telling the seed would give the secret away
+		return nil, fmt.Errorf("event %d is a raffle, it can be verified after it ends", event.ID)
+	}
+
+	winners, err := bot.db.GetWinners(event.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get participants: %v", err)
+	}
+	participants := splitOrder(winners)
+
+	users := make([]TempUser, len(participants))
+	for i, p := range participants {
+		users[i] = TempUser{ID: p.UserID, UserName: p.UserName, Weight: p.Weight}
+	}
+	shares, err := event.split(users)
+	if err != nil {
+		return nil, err
+	}
+
+	var mismatches []string
+	if event.IsRaffle() && event.EndedAt.Valid {
+		if err := event.verifyReveal(); err != nil {
+			mismatches = append(mismatches, err.Error())
+		}
+	}
+	return append(mismatches, splitMismatches(participants, shares)...), nil
+}
+
+// Compares the coins of the participants with the re-derived shares, both in
+// the split order. Returns a description of every mismatch.
+func splitMismatches(participants []Participant, shares []Coins) []string {
+	var mismatches []string
+	for i, p := range participants {
+		if p.Coins == shares[i] {
+			continue
+		}
+		if p.ClaimedAt.Valid && p.Coins < shares[i] {
+			// the claim was cut to what was left in the pool
+			continue
+		}
+		mismatches = append(mismatches, fmt.Sprintf(
+			"user %d got %s coins instead of %s", p.UserID, p.Coins, shares[i],
+		))
+	}
+	return mismatches
+}
@@ -0,0 +1,150 @@
+package skyaway
+
+import (
+	"database/sql"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func testUsers(weights ...int64) []TempUser {
+	users := make([]TempUser, len(weights))
+	for i, w := range weights {
+		users[i] = TempUser{ID: 101 + i, Weight: w}
+	}
+	return users
+}
+
+// Every strategy but fcfs hands out exactly the coins of the event, as long
+// as there is anyone to hand them to.
+func TestSplitsAddUp(t *testing.T) {
+	strategies := []DistributionStrategy{
+		EqualSplit{}, WeightedSplit{}, RandomSplit{3}, RandomSplit{20}, RaffleSplit{RandomSplit{2}},
+	}
+	userSets := map[string][]TempUser{
+		"no users":          nil,
+		"one user":          testUsers(4),
+		"weighted users":    testUsers(1, 5, 2, 0, 9, 1, 3),
+		"zero total weight": testUsers(0, 0, 0, 0, 0, 0, 0),
+	}
+
+	for _, strategy := range strategies {
+		for name, users := range userSets {
+			for _, coins := range []Coins{0, 1, 10, 1000003} {
+				shares := strategy.Split(coins, users, rand.New(rand.NewSource(42)))
+				if len(shares) != len(users) {
+					t.Errorf("%s, %s, %s coins: %d shares for %d users", strategy, name, coins, len(shares), len(users))
+					continue
+				}
+
+				var total, min, max Coins
+				winners := 0
+				for i, share := range shares {
+					total += share
+					if share > 0 {
+						winners++
+					}
+					if i == 0 || share < min {
+						min = share
+					}
+					if share > max {
+						max = share
+					}
+				}
+				if len(users) > 0 && total != coins {
+					t.Errorf("%s, %s: the shares add up to %s, expected %s", strategy, name, total, coins)
+				}
+				if random, ok := strategy.(RandomSplit); ok && winners > random.Winners {
+					t.Errorf("%s, %s: %d winners", strategy, name, winners)
+				}
+				if _, ok := strategy.(EqualSplit); ok && max-min > 1 {
+					t.Errorf("%s, %s: the shares differ by %s", strategy, name, max-min)
+				}
+			}
+		}
+	}
+}
+
+// A split is re-derived from the seed of the event, so it must not change.
+func TestSplitIsFixedBySeed(t *testing.T) {
+	users := testUsers(1, 5, 2, 0)
+	for _, test := range []struct {
+		strategy DistributionStrategy
+		coins    Coins
+		expected []Coins
+	}{
+		{EqualSplit{}, 10, []Coins{3, 3, 2, 2}},
+		{WeightedSplit{}, 1000, []Coins{125, 625, 250, 0}},
+		{RandomSplit{2}, 11, []Coins{6, 5, 0, 0}},
+		{RandomSplit{10}, 8, []Coins{2, 2, 2, 2}},
+		{FirstComeSplit{7}, 100, []Coins{7, 7, 7, 7}},
+	} {
+		shares := test.strategy.Split(test.coins, users, rand.New(rand.NewSource(42)))
+		if !reflect.DeepEqual(shares, test.expected) {
+			t.Errorf("%s of %d droplets: got %v, expected %v", test.strategy, test.coins, shares, test.expected)
+		}
+	}
+}
+
+func TestWeightedSplitZeroWeight(t *testing.T) {
+	users := testUsers(0, 0, 0)
+	weighted := WeightedSplit{}.Split(9, users, rand.New(rand.NewSource(1)))
+	equal := EqualSplit{}.Split(9, users, rand.New(rand.NewSource(1)))
+	if !reflect.DeepEqual(weighted, equal) {
+		t.Errorf("without any weight the split is %v, expected the equal split %v", weighted, equal)
+	}
+}
+
+func TestSplitMismatches(t *testing.T) {
+	claimed := NullTime{Valid: true}
+	participants := []Participant{
+		{UserID: 101, Coins: 5},
+		{UserID: 102, Coins: 3, ClaimedAt: claimed},
+		{UserID: 103, Coins: 4},
+		{UserID: 104, Coins: 6, ClaimedAt: claimed},
+	}
+	shares := []Coins{5, 5, 5, 5}
+
+	mismatches := splitMismatches(participants, shares)
+	expected := []string{
+		"user 103 got 0.000004 coins instead of 0.000005",
+		"user 104 got 0.000006 coins instead of 0.000005",
+	}
+	if !reflect.DeepEqual(mismatches, expected) {
+		t.Errorf("got %q, expected %q", mismatches, expected)
+	}
+}
+
+func TestSplitOrder(t *testing.T) {
+	participants := []Participant{
+		{UserID: 103}, {UserID: 101}, {UserID: 110, Late: true}, {UserID: 102},
+	}
+	var ids []int
+	for _, p := range splitOrder(participants) {
+		ids = append(ids, p.UserID)
+	}
+	if !reflect.DeepEqual(ids, []int{101, 102, 103}) {
+		t.Errorf("split order %v, expected 101 102 103", ids)
+	}
+}
+
+func TestEventSplitKeepsLateReserve(t *testing.T) {
+	event := &Event{
+		Coins:        1000,
+		Distribution: "weighted",
+		LatePool:     10,
+		Seed:         sql.NullInt64{Int64: 42, Valid: true},
+	}
+	shares, err := event.split(testUsers(1, 5, 2, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total Coins
+	for _, share := range shares {
+		total += share
+	}
+	// the late reserve is kept out of the split
+	if total != 900 {
+		t.Errorf("the shares add up to %s, expected 900 droplets", total)
+	}
+}
@@ -0,0 +1,190 @@
+package skyaway
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Decides whether a user may take part in an event, checked for every
+// enlisted user when the event starts.
+type EligibilityRule interface {
+	// Returns why the user is excluded, or an empty string if the user is
+	// eligible.
+	Check(tx *sqlx.Tx, event *Event, user *TempUser) (string, error)
+	// Returns the spec the rule is parsed from.
+	String() string
+}
+
+// Parses comma separated rule specs: "joined:DURATION", "username",
+// "messages:N" and "nowin:N". An empty spec or "none" means no rules.
+func ParseRules(specs string) ([]EligibilityRule, error) {
+	var rules []EligibilityRule
+	if specs == "none" {
+		return rules, nil
+	}
+
+	for _, spec := range strings.Split(specs, ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		rule, err := parseRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseRule(spec string) (EligibilityRule, error) {
+	kv := strings.SplitN(spec, ":", 2)
+	name, arg := kv[0], ""
+	if len(kv) == 2 {
+		arg = kv[1]
+	}
+
+	switch name {
+	case "joined":
+		d, err := parseDuration(arg)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("joined needs a duration, e.g. joined:7d")
+		}
+		return MinMembership{d}, nil
+	case "username":
+		return RequireUsername{}, nil
+	case "messages":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("messages needs a positive number, e.g. messages:10")
+		}
+		return MinMessages{n}, nil
+	case "nowin":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("nowin needs a positive number of events, e.g. nowin:3")
+		}
+		return NoRecentWin{n}, nil
+	default:
+		return nil, fmt.Errorf("unknown eligibility rule '%s'", name)
+	}
+}
+
+// Excludes users who have joined the group recently. Users who have not been
+// seen joining are counted from their first message, and excluded if the bot
+// has seen neither.
+type MinMembership struct {
+	Duration time.Duration
+}
+
+func (r MinMembership) Check(tx *sqlx.Tx, event *Event, user *TempUser) (string, error) {
+	since := user.JoinedAt
+	if !since.Valid {
+		since = user.FirstSeen
+	}
+	if !since.Valid {
+		return "joined at an unknown time", nil
+	}
+	if member := time.Since(since.Time); member < r.Duration {
+		return fmt.Sprintf(
+			"joined %s ago, needs %s", niceDuration(member), niceDuration(r.Duration),
+		), nil
+	}
+	return "", nil
+}
+
+func (r MinMembership) String() string {
+	return "joined:" + niceDuration(r.Duration)
+}
+
+// Excludes users without a public username.
+type RequireUsername struct{}
+
+func (RequireUsername) Check(tx *sqlx.Tx, event *Event, user *TempUser) (string, error) {
+	if user.UserName == "" {
+		return "has no username", nil
+	}
+	return "", nil
+}
+
+func (RequireUsername) String() string {
+	return "username"
+}
+
+// Excludes users who have written less than a number of messages in the
+// group.
+type MinMessages struct {
+	Messages int
+}
+
+func (r MinMessages) Check(tx *sqlx.Tx, event *Event, user *TempUser) (string, error) {
+	var messages int
+	err := tx.Get(&messages, tx.Rebind(
+		"select coalesce(sum(messages), 0) from activity where user_id = ?"),
+		user.ID,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to count messages: %v", err)
+	}
+	if messages < r.Messages {
+		return fmt.Sprintf("wrote %d messages, needs %d", messages, r.Messages), nil
+	}
+	return "", nil
+}
+
+func (r MinMessages) String() string {
+	return fmt.Sprintf("messages:%d", r.Messages)
+}
+
+// Excludes users who have claimed coins in any of the last few events.
+type NoRecentWin struct {
+	Events int
+}
+
+func (r NoRecentWin) Check(tx *sqlx.Tx, event *Event, user *TempUser) (string, error) {
+	var wins int
+	err := tx.Get(&wins, tx.Rebind(`
+		select count(*) from participant
+		where
+			user_id = ?
+			and claimed_at is not null
+			and coins > 0
+			and event_id in (
+				select id from event
+				where id != ? and started_at is not null
+				order by started_at desc
+				limit ?
+			)`),
+		user.ID, event.ID, r.Events,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to count recent wins: %v", err)
+	}
+	if wins > 0 {
+		return fmt.Sprintf("won in the last %d events", r.Events), nil
+	}
+	return "", nil
+}
+
+func (r NoRecentWin) String() string {
+	return fmt.Sprintf("nowin:%d", r.Events)
+}
+
+// Checks the user against all the rules, returns the reasons to exclude the
+// user joined together, or an empty string if the user is eligible.
+func checkEligibility(tx *sqlx.Tx, event *Event, user *TempUser, rules []EligibilityRule) (string, error) {
+	var reasons []string
+	for _, rule := range rules {
+		reason, err := rule.Check(tx, event, user)
+		if err != nil {
+			return "", err
+		}
+		if reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+	return strings.Join(reasons, "; "), nil
+}
@@ -1,6 +1,7 @@
 package skyaway
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/bcampbell/fuzzytime"
+	"github.com/therealssj/skyaway/address"
 	"gopkg.in/telegram-bot-api.v4"
 )
 
@@ -22,10 +24,16 @@ func (bot *Bot) handleCommandHelp(ctx *Context, command, args string) error {
 /help - this text
 /settings
 
-/scheduleevent [coins] [ISO timestamp, or human readable] [duration] [surprise] - start an event at timestamp and duration in hours
-/cancelevent - cancel a scheduled event
+/scheduleevent [coins] [ISO timestamp, or human readable] [duration] [surprise] [options] - start an event at timestamp and duration in hours
+/cancelevent [event id] - cancel a scheduled event, the next one by default
+/events - list the started event and the queue of scheduled events
+/editevent [event id] [coins|start|duration|surprise] [value] - change an event which has not started, surprise is yes or no
 /stopevent - stop current event
-/startevent [number of coins] [duration] - start an event immediately
+/extendevent [duration] - make the running event longer
+/shortenevent [duration] - make the running event shorter
+/pauseevent - stop accepting claims in the running event for now
+/resumeevent - accept claims again, the event ends later by the paused time
+/startevent [number of coins] [duration] [options] - start an event immediately
 /listevent  - list the current event (admins can also see surprise events)
 /adduser [username or id] - force add user to eligible list
 /makeadmin [username] - make a user an admin
@@ -37,13 +45,42 @@ func (bot *Bot) handleCommandHelp(ctx *Context, command, args string) error {
 /usercount - return number of users
 /users - return all users in list
 /bannedusers - return all users in banned list
-/listwinners - return a list of content winners`)
+/listwinners [event id, last or current] - return a list of content winners
+/verifysplit [event id, last or current] - re-derive the split of the coins from the event seed and check it
+/raffle [event id, last or current] - show the commitment, the secret and the draw order of a raffle
+/payouts - list the payouts which are not confirmed yet
+/treasury - show the wallet balance and the coins owed
+/setaddress [address] - save your skycoin address for claims
+/myaddress - show your saved address
+/clearaddress - forget your saved address
+/addresshistory [username or id] - show the saved address changes of a user
+/activity [username or id] - show the group activity of a user, or the most active users
+/addrecurrence [weekday or daily] [HH:MM] [coins] [duration] [surprise] [options] - schedule an event every week or day, the time is in UTC
+/recurrences - list the recurring events
+/pauserecurrence [id] - stop scheduling a recurring event for now
+/resumerecurrence [id] - start scheduling a paused recurring event again
+/deleterecurrence [id] - stop scheduling a recurring event for good
+/caps [username or id] - show how close a user is to the reward caps
+/addquestion [event id or current] [question] | [answer] | [another answer]... - users have to answer the question before claiming
+/questions [event id, last or current] - list the quiz questions of an event
+/removequestion [question id] - remove a quiz question
+
+Event options:
+payout=instant|batch - send each claim right away, or all of them at once when the event ends
+strategy=equal|fcfs:AMOUNT|weighted|random:N|raffle:N - split the coins equally, give AMOUNT to each claimer until the pool runs out, split by activity, split between N random users, or draw N winners with a published commitment
+late=PERCENT - reserve a percent of the coins for users who join during the event, first come first served
+countdown=DURATION,DURATION...|none - announce the event at these times before its start and end, e.g. 1d,6h,1h,15m,1m
+rules=joined:DURATION,username,messages:N,nowin:N|none - only let in users who joined at least DURATION ago, have a username, wrote at least N messages, or did not win in the last N events`)
 	}
 
 	return bot.Reply(ctx, `
 /start
 /help - this text
-/listevent - lists the current event`)
+/listevent - lists the current event
+/raffle [event id, last or current] - show the commitment, the secret and the draw order of a raffle
+/setaddress [address] - save your skycoin address for claims
+/myaddress - show your saved address
+/clearaddress - forget your saved address`)
 }
 
 // Handler for start command
@@ -188,6 +225,16 @@ func (bot *Bot) handleCommandUnBanUser(ctx *Context, command, args string) error
 // Handler for cancelevent command
 func (bot *Bot) handleCommandCancelEvent(ctx *Context, command, args string) error {
 	event := bot.db.GetCurrentEvent()
+	if args = strings.TrimSpace(args); args != "" {
+		id, err := strconv.Atoi(args)
+		if err != nil {
+			return bot.Reply(ctx, "usage: /cancelevent [event id]")
+		}
+		event = bot.db.GetEvent(id)
+		if event != nil && event.EndedAt.Valid {
+			return bot.ReplyAboutEvent(ctx, "the event has already ended", event)
+		}
+	}
 	if event == nil {
 		return bot.Reply(ctx, "nothing to cancel")
 	}
@@ -200,7 +247,7 @@ func (bot *Bot) handleCommandCancelEvent(ctx *Context, command, args string) err
 		)
 	}
 
-	if _, err := bot.EndCurrentEvent(); err != nil {
+	if err := bot.EndEvent(event); err != nil {
 		return fmt.Errorf("failed to cancel the event: %v", err)
 	}
 
@@ -209,22 +256,33 @@ func (bot *Bot) handleCommandCancelEvent(ctx *Context, command, args string) err
 
 // Handler for scheduleevent command
 func (bot *Bot) handleCommandScheduleEvent(ctx *Context, command, args string) error {
-	coins, start, duration, surprise, err := parseScheduleEventArgs(args)
+	opts, words, err := bot.parseEventOptions(strings.Fields(args))
 	if err != nil {
 		return fmt.Errorf("could not understand: %v", err)
 	}
 
-	haveCurrent, err := bot.complainIfHaveCurrentEvent(ctx)
-	if haveCurrent || err != nil {
+	coins, start, duration, surprise, err := parseScheduleEventArgs(strings.Join(words, " "))
+	if err != nil {
+		return fmt.Errorf("could not understand: %v", err)
+	}
+
+	if err := bot.checkOverlap(start, duration, 0); err != nil {
+		if overlap, ok := err.(*EventsOverlap); ok {
+			return bot.ReplyAboutEvent(ctx, overlap.Error(), overlap.Event)
+		}
 		return err
 	}
 
-	err = bot.db.ScheduleEvent(coins, start, duration, surprise)
+	if err := bot.guardFunds(coins, nil); err != nil {
+		return bot.Reply(ctx, fmt.Sprintf("cannot schedule the event: %v", err))
+	}
+
+	id, err := bot.db.ScheduleEvent(coins, start, duration, surprise, opts)
 	if err != nil {
 		return fmt.Errorf("failed to schedule event: %v", err)
 	}
 
-	event := bot.db.GetCurrentEvent()
+	event := bot.db.GetEvent(id)
 	if event == nil {
 		return fmt.Errorf("event was not scheduled due to reasons unknown")
 	}
@@ -263,11 +321,18 @@ func (bot *Bot) handleCommandSettings(ctx *Context, command, args string) error
 
 // Handler for startevent commnad
 func (bot *Bot) handleCommandStartEvent(ctx *Context, command, args string) error {
-	words := strings.Fields(args)
-	coins, err := strconv.Atoi(words[0])
+	opts, words, err := bot.parseEventOptions(strings.Fields(args))
+	if err != nil {
+		return bot.Reply(ctx, err.Error())
+	}
+	if len(words) < 2 {
+		return bot.Reply(ctx, "usage: /startevent [number of coins] [duration]")
+	}
+
+	coins, err := ParseCoins(words[0])
 
 	if err != nil {
-		return bot.Reply(ctx, "malformed coins format: use an integer number")
+		return bot.Reply(ctx, "malformed coins format: use a number with up to 6 decimals")
 	}
 
 	dur, err := parseDuration(words[1])
@@ -281,10 +346,16 @@ func (bot *Bot) handleCommandStartEvent(ctx *Context, command, args string) erro
 		true,
 	}
 
-	event, err := bot.StartNewEvent(coins, duration)
+	event, err := bot.StartNewEvent(coins, duration, opts)
 	if err == EventExists {
 		return bot.ReplyAboutEvent(ctx, "already have an event", event)
 	}
+	if overlap, ok := err.(*EventsOverlap); ok {
+		return bot.ReplyAboutEvent(ctx, overlap.Error(), overlap.Event)
+	}
+	if shortage, ok := err.(*InsufficientFunds); ok {
+		return bot.Reply(ctx, fmt.Sprintf("cannot start the event: %v", shortage))
+	}
 	if err != nil {
 		return err
 	}
@@ -292,6 +363,238 @@ func (bot *Bot) handleCommandStartEvent(ctx *Context, command, args string) erro
 	return bot.ReplyAboutEvent(ctx, "event started", event)
 }
 
+// Handler for editevent command
+func (bot *Bot) handleCommandEditEvent(ctx *Context, command, args string) error {
+	usage := "usage: /editevent [event id] [coins|start|duration|surprise] [value]"
+	words := strings.Fields(args)
+	if len(words) < 3 {
+		return bot.Reply(ctx, usage)
+	}
+
+	id, err := strconv.Atoi(words[0])
+	if err != nil {
+		return bot.Reply(ctx, usage)
+	}
+	event := bot.db.GetEvent(id)
+	if event == nil {
+		return bot.Reply(ctx, "no such event")
+	}
+	if event.EndedAt.Valid {
+		return bot.ReplyAboutEvent(ctx, "the event has already ended", event)
+	}
+	if event.StartedAt.Valid {
+		return bot.ReplyAboutEvent(ctx, "the event has already started", event)
+	}
+
+	edited := *event
+	value := strings.Join(words[2:], " ")
+	switch words[1] {
+	case "coins":
+		if edited.Coins, err = ParseCoins(value); err != nil {
+			return bot.Reply(ctx, "malformed coins format: use a number with up to 6 decimals")
+		}
+	case "start":
+		start, err := parseStartTime(value)
+		if err != nil {
+			return bot.Reply(ctx, fmt.Sprintf("could not understand: %v", err))
+		}
+		edited.ScheduledAt = NewNullTime(start)
+	case "duration":
+		dur, err := parseDuration(value)
+		if err != nil {
+			return bot.Reply(ctx, "malformed duration format")
+		}
+		edited.Duration = Duration{dur, true}
+	case "surprise":
+		switch value {
+		case "yes":
+			edited.Surprise = true
+		case "no":
+			edited.Surprise = false
+		default:
+			return bot.Reply(ctx, "surprise should be 'yes' or 'no'")
+		}
+	default:
+		return bot.Reply(ctx, usage)
+	}
+
+	err = bot.checkOverlap(edited.ScheduledAt.Time, edited.Duration, edited.ID)
+	if overlap, ok := err.(*EventsOverlap); ok {
+		return bot.ReplyAboutEvent(ctx, overlap.Error(), overlap.Event)
+	}
+	if err != nil {
+		return err
+	}
+
+	if edited.Coins > event.Coins {
+		if err := bot.guardFunds(edited.Coins, event); err != nil {
+			return bot.Reply(ctx, fmt.Sprintf("cannot edit the event: %v", err))
+		}
+	}
+
+	changed, err := bot.db.EditEvent(&edited)
+	if err != nil {
+		return fmt.Errorf("failed to edit event: %v", err)
+	}
+	if !changed {
+		return bot.Reply(ctx, "the event has started or ended meanwhile")
+	}
+	defer bot.Reschedule()
+
+	if edited.Surprise {
+		// the announced details are out of date and should not be public
+		if err := bot.DeleteStatus(&edited); err != nil {
+			log.Printf("failed to take down the status message: %v", err)
+		}
+		return bot.ReplyAboutEvent(ctx, "event updated", &edited)
+	}
+
+	// editing the status message in place notifies nobody
+	announced := edited.StatusMessageID.Valid
+	bot.AnnounceEventWithTitle(&edited, "Event updated")
+	if announced {
+		var notice string
+		switch words[1] {
+		case "coins":
+			notice = fmt.Sprintf("the upcoming event now gives away %s coins", edited.Coins)
+		case "start":
+			notice = fmt.Sprintf("the upcoming event now starts in %s", niceDuration(time.Until(edited.ScheduledAt.Time)))
+		case "duration":
+			notice = fmt.Sprintf("the upcoming event now lasts %s", niceDuration(edited.Duration.Duration))
+		}
+		if notice != "" {
+			if err := bot.Send(ctx, "yell", "text", notice); err != nil {
+				log.Printf("failed to post the event update: %v", err)
+			}
+		}
+	}
+	return bot.ReplyAboutEvent(ctx, "event updated", &edited)
+}
+
+// Handler for extendevent and shortenevent commands
+func (bot *Bot) handleCommandExtendEvent(ctx *Context, command, args string) error {
+	dur, err := parseDuration(strings.TrimSpace(args))
+	if err != nil || dur <= 0 {
+		return bot.Reply(ctx, fmt.Sprintf("usage: /%s [duration]", command))
+	}
+
+	event := bot.db.GetCurrentEvent()
+	if event == nil || !event.StartedAt.Valid {
+		return bot.Reply(ctx, "no event is running")
+	}
+
+	extend := command == "extendevent"
+	duration := event.Duration
+	if extend {
+		duration.Duration += dur
+		err := bot.checkOverlap(event.StartedAt.Time, duration, event.ID)
+		if overlap, ok := err.(*EventsOverlap); ok {
+			return bot.ReplyAboutEvent(ctx, overlap.Error(), overlap.Event)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		duration.Duration -= dur
+		if !event.StartedAt.Time.Add(duration.Duration).After(time.Now()) {
+			return bot.ReplyAboutEvent(ctx, "the event would end in the past, use /stopevent instead", event)
+		}
+	}
+
+	changed, err := bot.db.SetEventDuration(event, duration)
+	if err != nil {
+		return fmt.Errorf("failed to change the event duration: %v", err)
+	}
+	if !changed {
+		return bot.Reply(ctx, "the event has ended meanwhile")
+	}
+	defer bot.Reschedule()
+
+	verb := "shortened"
+	if extend {
+		verb = "extended"
+	}
+	bot.AnnounceEventWithTitle(event, fmt.Sprintf(
+		"Event %s, it ends at %s", verb, event.EndsAt().Format("Jan 2 2006, 15:04:05 -0700"),
+	))
+	return bot.ReplyAboutEvent(ctx, "event "+verb, event)
+}
+
+// Handler for pauseevent and resumeevent commands
+func (bot *Bot) handleCommandPauseEvent(ctx *Context, command, args string) error {
+	event := bot.db.GetCurrentEvent()
+	if event == nil || !event.StartedAt.Valid {
+		return bot.Reply(ctx, "no event is running")
+	}
+
+	if command == "pauseevent" {
+		paused, err := bot.db.PauseEvent(event)
+		if err != nil {
+			return err
+		}
+		if !paused {
+			return bot.ReplyAboutEvent(ctx, "the event is paused already", event)
+		}
+		bot.Reschedule()
+
+		bot.AnnounceEventWithTitle(event, "Event paused, claims are not accepted until it resumes")
+		return bot.ReplyAboutEvent(ctx, "event paused", event)
+	}
+
+	resumed, err := bot.db.ResumeEvent(event)
+	if err != nil {
+		return err
+	}
+	if !resumed {
+		return bot.ReplyAboutEvent(ctx, "the event is not paused", event)
+	}
+	bot.Reschedule()
+
+	bot.AnnounceEventWithTitle(event, fmt.Sprintf(
+		"Event resumed, it ends at %s", event.EndsAt().Format("Jan 2 2006, 15:04:05 -0700"),
+	))
+	reply := "event resumed"
+	if overlap, ok := bot.checkOverlap(event.StartedAt.Time, event.Duration, event.ID).(*EventsOverlap); ok {
+		reply += fmt.Sprintf(", it now overlaps event %d, which will start late", overlap.Event.ID)
+	}
+	return bot.ReplyAboutEvent(ctx, reply, event)
+}
+
+// Handler for events command
+func (bot *Bot) handleCommandEvents(ctx *Context, command, args string) error {
+	events, err := bot.db.GetOpenEvents()
+	if err != nil {
+		return fmt.Errorf("failed to get events from db: %v", err)
+	}
+
+	var lines []string
+	for _, e := range events {
+		var line string
+		if e.StartedAt.Valid {
+			line = fmt.Sprintf(
+				"%d. %s coins for %s, started %s",
+				e.ID, e.Coins, niceDuration(e.Duration.Duration),
+				e.StartedAt.Time.Format("Jan 2 2006, 15:04 MST"),
+			)
+		} else {
+			line = fmt.Sprintf(
+				"%d. %s coins for %s, starts %s",
+				e.ID, e.Coins, niceDuration(e.Duration.Duration),
+				e.ScheduledAt.Time.Format("Jan 2 2006, 15:04 MST"),
+			)
+		}
+		if e.Surprise {
+			line += ", surprise"
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) > 0 {
+		return bot.Reply(ctx, strings.Join(lines, "\n"))
+	} else {
+		return bot.Reply(ctx, "no events")
+	}
+}
+
 // Handler for stopevent command
 func (bot *Bot) handleCommandStopEvent(ctx *Context, command, args string) error {
 	event := bot.db.GetCurrentEvent()
@@ -367,44 +670,515 @@ func (bot *Bot) handleCommandUsersParsed(ctx *Context, banned bool) error {
 	}
 }
 
+// Handler for payouts command
+func (bot *Bot) handleCommandPayouts(ctx *Context, command, args string) error {
+	payouts, err := bot.db.GetUnfinishedPayouts()
+	if err != nil {
+		return fmt.Errorf("failed to get payouts from db: %v", err)
+	}
+
+	var lines []string
+	for i := range payouts {
+		lines = append(lines, formatPayout(&payouts[i]))
+	}
+	if len(lines) > 0 {
+		return bot.Reply(ctx, strings.Join(lines, "\n"))
+	} else {
+		return bot.Reply(ctx, "all payouts are confirmed")
+	}
+}
+
+// Handler for treasury command
+func (bot *Bot) handleCommandTreasury(ctx *Context, command, args string) error {
+	owed, err := bot.db.CoinsOwed(nil)
+	if err != nil {
+		return err
+	}
+
+	rollover, err := bot.db.RolloverBalance()
+	if err != nil {
+		return fmt.Errorf("failed to get the rollover balance: %v", err)
+	}
+	note := ""
+	if rollover > 0 {
+		note = fmt.Sprintf(", %s of them put aside for the next event", rollover)
+	}
+
+	if bot.payer == nil {
+		return bot.Reply(ctx, fmt.Sprintf("no wallet configured, %s coins owed%s", owed, note))
+	}
+
+	balance, err := bot.payer.Balance()
+	if err == BalanceUnknown {
+		return bot.Reply(ctx, fmt.Sprintf("the wallet balance is unknown, %s coins owed%s", owed, note))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get the wallet balance: %v", err)
+	}
+
+	return bot.Reply(ctx, fmt.Sprintf(
+		"balance: %s coins\nowed: %s coins%s",
+		Coins(balance), owed, note,
+	))
+}
+
+// Handler for setaddress command
+func (bot *Bot) handleCommandSetAddress(ctx *Context, command, args string) error {
+	addr := strings.TrimSpace(args)
+	if addr == "" {
+		return bot.Reply(ctx, "usage: /setaddress [skycoin address]")
+	}
+	if err := address.Validate(addr); err != nil {
+		return bot.Reply(ctx, fmt.Sprintf("%s is not a valid skycoin address: %v", addr, err))
+	}
+
+	if err := bot.db.SetSavedAddress(ctx.User, addr); err != nil {
+		return fmt.Errorf("failed to save the address: %v", err)
+	}
+	log.Printf("%s saved address %s", ctx.User.NameAndTags(), addr)
+	return bot.Reply(ctx, fmt.Sprintf("your coins will be offered to go to %s from now on", addr))
+}
+
+// Handler for myaddress command
+func (bot *Bot) handleCommandMyAddress(ctx *Context, command, args string) error {
+	saved, err := bot.db.GetSavedAddress(ctx.User)
+	if err != nil {
+		return fmt.Errorf("failed to get the saved address: %v", err)
+	}
+	if saved == "" {
+		return bot.Reply(ctx, "you have no saved address, use /setaddress to save one")
+	}
+	return bot.Reply(ctx, fmt.Sprintf("your saved address is %s", saved))
+}
+
+// Handler for clearaddress command
+func (bot *Bot) handleCommandClearAddress(ctx *Context, command, args string) error {
+	if err := bot.db.SetSavedAddress(ctx.User, ""); err != nil {
+		return fmt.Errorf("failed to clear the address: %v", err)
+	}
+	log.Printf("%s cleared their saved address", ctx.User.NameAndTags())
+	return bot.Reply(ctx, "your saved address is cleared")
+}
+
+// Handler for addresshistory command
+func (bot *Bot) handleCommandAddressHistory(ctx *Context, command, args string) error {
+	user := bot.db.GetUserByNameOrId(args)
+	if user == nil {
+		return bot.Reply(ctx, "no user by that name or id")
+	}
+
+	changes, err := bot.db.GetAddressChanges(user)
+	if err != nil {
+		return fmt.Errorf("failed to get address changes from db: %v", err)
+	}
+
+	var lines []string
+	for _, change := range changes {
+		from, to := "none", "none"
+		if change.OldAddress.Valid {
+			from = change.OldAddress.String
+		}
+		if change.NewAddress.Valid {
+			to = change.NewAddress.String
+		}
+		lines = append(lines, fmt.Sprintf(
+			"%s: %s -> %s",
+			change.ChangedAt.Format("Jan 2 2006, 15:04:05 -0700"), from, to,
+		))
+	}
+	if len(lines) > 0 {
+		return bot.Reply(ctx, strings.Join(lines, "\n"))
+	} else {
+		return bot.Reply(ctx, fmt.Sprintf("%s has never saved an address", user.NameAndTags()))
+	}
+}
+
 // Handler for listwinners command
 func (bot *Bot) handleCommandListWinners(ctx *Context, command, args string) error {
-	var eventID int
-	var err error
-
-	// get last or current event id
-	if args == "last" {
-		event := bot.db.GetLastEvent()
-		eventID = event.ID
-	} else if args == "current" {
-		event := bot.db.GetCurrentEvent()
-		eventID = event.ID
-	} else {
-		// check if input argument is an integer
-		eventID, err = strconv.Atoi(args)
+	event, err := bot.findEvent(args)
+	if err != nil {
+		return bot.Reply(ctx, err.Error())
+	}
+
+	winners, err := bot.db.GetWinners(event.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to get users from db: %v", err)
+	}
+
+	var lines []string
+	if event.Commitment.Valid {
+		lines = formatDraw(event, winners)
+	}
+	header := len(lines)
+	for _, winner := range winners {
+		if winner.Coins == 0 {
+			// not drawn in a random split
+			continue
+		}
+		line := fmt.Sprintf(
+			"%d. %d: %s: coinswon -> %s", len(lines)-header+1, winner.UserID, winner.UserName, winner.Coins,
+		)
+		if winner.TxID.Valid {
+			line += fmt.Sprintf(", tx %s", winner.TxID.String)
+		}
+		lines = append(lines, line)
+	}
+	won := len(lines) > header
+
+	exclusions, err := bot.db.GetExclusions(event.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get exclusions from db: %v", err)
+	}
+	if len(exclusions) > 0 {
+		lines = append(lines, "excluded:")
+	}
+	for _, exclusion := range exclusions {
+		lines = append(lines, fmt.Sprintf(
+			"%d: %s: %s", exclusion.UserID, exclusion.UserName, exclusion.Reason,
+		))
+	}
+
+	if !won {
+		lines = append(lines, "no winners, that's weird")
+	}
+	return bot.Reply(ctx, strings.Join(lines, "\n"))
+}
+
+// Handler for raffle command, open to everyone so that anyone can redo the
+// draw
+func (bot *Bot) handleCommandRaffle(ctx *Context, command, args string) error {
+	if args == "" {
+		args = "last"
+	}
+	event, err := bot.findEvent(args)
+	if err != nil {
+		return bot.Reply(ctx, err.Error())
+	}
+	if !event.Commitment.Valid {
+		return bot.Reply(ctx, fmt.Sprintf("event %d is not a raffle", event.ID))
+	}
+
+	participants, err := bot.db.GetWinners(event.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get participants: %v", err)
+	}
+	lines := formatDraw(event, participants)
+	if event.Reveal.Valid {
+		var winners []string
+		for _, p := range splitOrder(participants) {
+			if p.Coins > 0 {
+				winners = append(winners, strconv.Itoa(p.UserID))
+			}
+		}
+		lines = append(lines, fmt.Sprintf("winners: %s", strings.Join(winners, " ")))
+	}
+	return bot.Reply(ctx, strings.Join(lines, "\n"))
+}
+
+// Handler for verifysplit command
+func (bot *Bot) handleCommandVerifySplit(ctx *Context, command, args string) error {
+	event, err := bot.findEvent(args)
+	if err != nil {
+		return bot.Reply(ctx, err.Error())
+	}
+
+	mismatches, err := bot.VerifySplit(event)
+	if err != nil {
+		return err
+	}
+	if len(mismatches) > 0 {
+		return bot.Reply(ctx, fmt.Sprintf(
+			"the split of event %d does not match its seed %d:\n%s",
+			event.ID, event.Seed.Int64, strings.Join(mismatches, "\n"),
+		))
+	}
+	return bot.Reply(ctx, fmt.Sprintf(
+		"the split of event %d matches its seed %d", event.ID, event.Seed.Int64,
+	))
+}
+
+// Handler for activity command
+func (bot *Bot) handleCommandActivity(ctx *Context, command, args string) error {
+	if args == "" {
+		users, messages, err := bot.db.GetMostActive(time.Now().AddDate(0, 0, -30), 10)
 		if err != nil {
-			return bot.Reply(ctx, fmt.Sprintf("invalid input argument: %s", args))
+			return fmt.Errorf("failed to get activity from db: %v", err)
+		}
+
+		var lines []string
+		for i, user := range users {
+			lines = append(lines, fmt.Sprintf(
+				"%d. %s: %d messages", i+1, user.NameAndTags(), messages[i],
+			))
+		}
+		if len(lines) > 0 {
+			return bot.Reply(ctx, "most active in the last 30 days:\n"+strings.Join(lines, "\n"))
+		} else {
+			return bot.Reply(ctx, "nobody has written in the last 30 days")
 		}
 	}
 
-	winners, err := bot.db.GetWinners(eventID)
+	user := bot.db.GetUserByNameOrId(args)
+	if user == nil {
+		return bot.Reply(ctx, "no user by that name or id")
+	}
 
+	total, err := bot.db.CountMessages(user, time.Time{})
 	if err != nil {
-		return fmt.Errorf("failed to get users from db: %v", err)
+		return fmt.Errorf("failed to count messages: %v", err)
+	}
+	days, err := bot.db.GetActivity(user, time.Now().AddDate(0, 0, -7))
+	if err != nil {
+		return fmt.Errorf("failed to get activity from db: %v", err)
+	}
+
+	formatTime := func(t NullTime) string {
+		if !t.Valid {
+			return "never"
+		}
+		return t.Time.Format("Jan 2 2006, 15:04:05 -0700")
+	}
+	lines := []string{
+		user.NameAndTags(),
+		fmt.Sprintf("joined: %s", formatTime(user.JoinedAt)),
+		fmt.Sprintf("first seen: %s", formatTime(user.FirstSeen)),
+		fmt.Sprintf("last seen: %s", formatTime(user.LastSeen)),
+		fmt.Sprintf("messages: %d", total),
+	}
+	if len(days) > 0 {
+		lines = append(lines, "last 7 days:")
+	}
+	for _, day := range days {
+		lines = append(lines, fmt.Sprintf("%s: %d", day.Day.Format("Jan 2 2006"), day.Messages))
+	}
+	return bot.Reply(ctx, strings.Join(lines, "\n"))
+}
+
+// Handler for caps command
+func (bot *Bot) handleCommandCaps(ctx *Context, command, args string) error {
+	if bot.caps == nil {
+		return bot.Reply(ctx, "no caps configured")
+	}
+
+	user := bot.db.GetUserByNameOrId(args)
+	if user == nil {
+		return bot.Reply(ctx, "no user by that name or id")
+	}
+
+	usage, err := bot.db.GetCapUsage(user, bot.caps.WinsWindow)
+	if err != nil {
+		return err
+	}
+
+	lines := []string{user.NameAndTags()}
+	if bot.caps.MonthlyCoins > 0 {
+		lines = append(lines, fmt.Sprintf(
+			"this month: %s of %s coins", usage.MonthlyCoins, bot.caps.MonthlyCoins,
+		))
+	}
+	if bot.caps.Wins > 0 {
+		lines = append(lines, fmt.Sprintf(
+			"wins in the last %s: %d of %d",
+			niceDuration(bot.caps.WinsWindow), usage.Wins, bot.caps.Wins,
+		))
+	}
+	return bot.Reply(ctx, strings.Join(lines, "\n"))
+}
+
+// Handler for addrecurrence command
+func (bot *Bot) handleCommandAddRecurrence(ctx *Context, command, args string) error {
+	usage := "usage: /addrecurrence [weekday or daily] [HH:MM] [coins] [duration] [surprise] [options]"
+	words := strings.Fields(args)
+	if len(words) < 4 {
+		return bot.Reply(ctx, usage)
+	}
+
+	weekday, timeOfDay, err := parseRecurrenceTime(words[0], words[1])
+	if err != nil {
+		return bot.Reply(ctx, err.Error())
+	}
+
+	coins, err := ParseCoins(words[2])
+	if err != nil {
+		return bot.Reply(ctx, "malformed coins format: use a number with up to 6 decimals")
+	}
+
+	dur, err := parseDuration(words[3])
+	if err != nil {
+		return bot.Reply(ctx, "malformed duration format")
+	}
+
+	words = words[4:]
+	surprise := len(words) > 0 && words[0] == "surprise"
+	if surprise {
+		words = words[1:]
+	}
+	if _, rest, err := bot.parseEventOptions(words); err != nil {
+		return bot.Reply(ctx, err.Error())
+	} else if len(rest) > 0 {
+		return bot.Reply(ctx, usage)
+	}
+
+	r := Recurrence{
+		TimeOfDay: timeOfDay,
+		Coins:     coins,
+		Duration:  Duration{dur, true},
+		Surprise:  surprise,
+		Options:   strings.Join(words, " "),
+	}
+	if weekday >= 0 {
+		r.Weekday = sql.NullInt64{Int64: int64(weekday), Valid: true}
+	}
+	if err := bot.db.AddRecurrence(&r); err != nil {
+		return fmt.Errorf("failed to add the recurrence: %v", err)
+	}
+	bot.Reschedule()
+
+	return bot.Reply(ctx, fmt.Sprintf("recurrence %d added: %s", r.ID, r.String()))
+}
+
+// Handler for recurrences command
+func (bot *Bot) handleCommandRecurrences(ctx *Context, command, args string) error {
+	recurrences, err := bot.db.GetRecurrences(true)
+	if err != nil {
+		return fmt.Errorf("failed to get recurrences from db: %v", err)
+	}
+
+	var lines []string
+	for _, r := range recurrences {
+		line := fmt.Sprintf("%d. %s", r.ID, r.String())
+		if !r.Paused {
+			after := time.Now()
+			if r.LastScheduledAt.Valid && r.LastScheduledAt.Time.After(after) {
+				after = r.LastScheduledAt.Time
+			}
+			line += fmt.Sprintf(", next %s", r.Next(after).Format("Jan 2 2006, 15:04 MST"))
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) > 0 {
+		return bot.Reply(ctx, strings.Join(lines, "\n"))
+	} else {
+		return bot.Reply(ctx, "no recurrences")
+	}
+}
+
+// Handler for pauserecurrence and resumerecurrence commands
+func (bot *Bot) handleCommandPauseRecurrence(ctx *Context, command, args string) error {
+	id, err := strconv.Atoi(args)
+	if err != nil {
+		return bot.Reply(ctx, fmt.Sprintf("invalid input argument: %s", args))
+	}
+
+	paused := command == "pauserecurrence"
+	found, err := bot.db.PauseRecurrence(id, paused)
+	if err != nil {
+		return fmt.Errorf("failed to update the recurrence: %v", err)
+	}
+	if !found {
+		return bot.Reply(ctx, "no such recurrence")
+	}
+	bot.Reschedule()
+
+	if paused {
+		return bot.Reply(ctx, fmt.Sprintf("recurrence %d paused, the event it has scheduled already is kept", id))
+	}
+	return bot.Reply(ctx, fmt.Sprintf("recurrence %d resumed", id))
+}
+
+// Handler for deleterecurrence command
+func (bot *Bot) handleCommandDeleteRecurrence(ctx *Context, command, args string) error {
+	id, err := strconv.Atoi(args)
+	if err != nil {
+		return bot.Reply(ctx, fmt.Sprintf("invalid input argument: %s", args))
+	}
+
+	found, err := bot.db.DeleteRecurrence(id)
+	if err != nil {
+		return fmt.Errorf("failed to delete the recurrence: %v", err)
+	}
+	if !found {
+		return bot.Reply(ctx, "no such recurrence")
+	}
+	return bot.Reply(ctx, fmt.Sprintf("recurrence %d deleted, the event it has scheduled already is kept", id))
+}
+
+// Handler for addquestion command
+func (bot *Bot) handleCommandAddQuestion(ctx *Context, command, args string) error {
+	parts := strings.Split(args, "|")
+	words := strings.Fields(parts[0])
+	if len(parts) < 2 || len(words) < 2 {
+		return bot.Reply(ctx, "usage: /addquestion [event id or current] [question] | [answer] | [another answer]...")
+	}
+
+	event, err := bot.findEvent(words[0])
+	if err != nil {
+		return bot.Reply(ctx, err.Error())
+	}
+	if event.EndedAt.Valid {
+		return bot.Reply(ctx, "the event has ended already")
+	}
+
+	var answers []string
+	for _, answer := range parts[1:] {
+		if answer = strings.TrimSpace(answer); answer != "" {
+			answers = append(answers, answer)
+		}
+	}
+	if len(answers) == 0 {
+		return bot.Reply(ctx, "the question needs at least one accepted answer")
+	}
+
+	question := strings.Join(words[1:], " ")
+	if err := bot.db.AddQuestion(event.ID, question, answers); err != nil {
+		return fmt.Errorf("failed to add the question: %v", err)
+	}
+	return bot.Reply(ctx, fmt.Sprintf("question added to event %d", event.ID))
+}
+
+// Handler for questions command
+func (bot *Bot) handleCommandQuestions(ctx *Context, command, args string) error {
+	event, err := bot.findEvent(args)
+	if err != nil {
+		return bot.Reply(ctx, err.Error())
+	}
+
+	questions, err := bot.db.GetQuestions(event.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get questions from db: %v", err)
 	}
 
 	var lines []string
-	for i, winner := range winners {
+	for _, q := range questions {
 		lines = append(lines, fmt.Sprintf(
-			"%d. %d: %s: coinswon -> %d", (i+1), winner.UserID, winner.UserName, winner.Coins,
+			"%d. %s -> %s", q.ID, q.Question, strings.Replace(q.Answers, "|", " | ", -1),
 		))
 	}
 	if len(lines) > 0 {
 		return bot.Reply(ctx, strings.Join(lines, "\n"))
 	} else {
-		return bot.Reply(ctx, "no winners, that's weird")
+		return bot.Reply(ctx, fmt.Sprintf("event %d has no quiz", event.ID))
+	}
+}
+
+// Handler for removequestion command
+func (bot *Bot) handleCommandRemoveQuestion(ctx *Context, command, args string) error {
+	id, err := strconv.Atoi(args)
+	if err != nil {
+		return bot.Reply(ctx, fmt.Sprintf("invalid input argument: %s", args))
+	}
+
+	removed, err := bot.db.RemoveQuestion(id)
+	if err != nil {
+		return fmt.Errorf("failed to remove the question: %v", err)
+	}
+	if !removed {
+		return bot.Reply(ctx, "no such question in an event which has not ended")
 	}
+	return bot.Reply(ctx, "question removed")
 }
+
 func (bot *Bot) handleDirectMessageFallback(ctx *Context, text string) (bool, error) {
 	event := bot.db.GetCurrentEvent()
 
@@ -422,11 +1196,118 @@ func (bot *Bot) handleDirectMessageFallback(ctx *Context, text string) (bool, er
 				return true, bot.Reply(ctx, "event has not started yet, come back later")
 			}
 		}
+
+		return true, bot.handleClaim(ctx, event, text)
 	}
 
 	return true, bot.Reply(ctx, "no upcoming events, check back later")
 }
 
+// Walks the user through claiming their coins in the started `event`: asks
+// for a skycoin address first, then records the claim once the user replies
+// with one.
+func (bot *Bot) handleClaim(ctx *Context, event *Event, text string) error {
+	coins, err := bot.db.GetCoinsToClaim(ctx.User, event)
+	switch err {
+	case nil:
+	case NotParticipating:
+		return bot.Reply(ctx, "you are not on the list of this event, wait for the next one")
+	case AlreadyClaimed:
+		return bot.Reply(ctx, fmt.Sprintf("you have already claimed your %s coins in this event", coins))
+	default:
+		return fmt.Errorf("failed to get coins to claim: %v", err)
+	}
+	if coins == 0 {
+		return bot.Reply(ctx, "you have not been drawn in this event, better luck next time")
+	}
+	if event.PausedAt.Valid {
+		return bot.Reply(ctx, "the claims are paused for now, try again later")
+	}
+
+	passed, err := bot.quizPassed(ctx.User, event)
+	if err != nil {
+		return err
+	}
+	if !passed {
+		return bot.Reply(ctx, "answer the quiz of this event in a private message to me first")
+	}
+
+	saved, err := bot.db.GetSavedAddress(ctx.User)
+	if err != nil {
+		return fmt.Errorf("failed to get the saved address: %v", err)
+	}
+
+	// the saved address is only shown and used in private
+	private := ctx.message.Chat.IsPrivate()
+	addr := strings.TrimSpace(text)
+	if saved != "" && private && isConfirmation(addr) {
+		addr = saved
+	}
+
+	if !looksLikeAddress(addr) {
+		if saved != "" && private {
+			return bot.Ask(ctx, fmt.Sprintf(
+				"you can claim %s coins, reply 'yes' to get them at your saved address %s, or send me another skycoin address",
+				coins, saved,
+			))
+		}
+		if saved != "" {
+			return bot.Reply(ctx, fmt.Sprintf(
+				"you can claim %s coins, send me a private message to get them at your saved address", coins,
+			))
+		}
+		return bot.Ask(ctx, fmt.Sprintf(
+			"you can claim %s coins, what is your skycoin address?", coins,
+		))
+	}
+
+	if err := address.Validate(addr); err != nil {
+		return bot.Ask(ctx, fmt.Sprintf(
+			"%s is not a valid skycoin address: %v. Please check it and send it again.",
+			addr, err,
+		))
+	}
+
+	coins, err = bot.db.ClaimCoins(ctx.User, event, addr, bot.caps)
+	if err == AlreadyClaimed {
+		return bot.Reply(ctx, "you have already claimed your coins in this event")
+	}
+	if err == NoCoinsLeft {
+		return bot.Reply(ctx, "sorry, all the coins of this event have been claimed already")
+	}
+	if err == CapReached {
+		return bot.Reply(ctx, "sorry, you have reached the reward cap, leave some coins for the others")
+	}
+	if err == EventPaused {
+		return bot.Reply(ctx, "the claims are paused for now, try again later")
+	}
+	if err == EventEnded {
+		return bot.Reply(ctx, "sorry, this event has ended, wait for the next one")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to claim coins: %v", err)
+	}
+
+	log.Printf("%s claimed %s coins to %s", ctx.User.NameAndTags(), coins, addr)
+	if err := bot.UpdateStatus(event, "Event is ongoing"); err != nil {
+		log.Printf("failed to update the status after a claim: %v", err)
+	}
+	if event.PayoutMode == PayoutModeBatch {
+		err = bot.Reply(ctx, fmt.Sprintf("%s coins will be sent to %s when the event ends", coins, addr))
+	} else {
+		bot.PokePayouts()
+		err = bot.Reply(ctx, fmt.Sprintf("%s coins will be sent to %s shortly", coins, addr))
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := bot.EndCurrentEventIfNeeded(); err != nil {
+		return fmt.Errorf("failed to end the event after a claim: %v", err)
+	}
+	return nil
+}
+
 func (bot *Bot) AddPrivateMessageHandler(handler MessageHandler) {
 	bot.privateMessageHandlers = append(bot.privateMessageHandlers, handler)
 }
@@ -446,25 +1327,91 @@ func (bot *Bot) enableUserVerbosely(ctx *Context, dbuser *User) error {
 	return bot.Reply(ctx, "no action required")
 }
 
-func (bot *Bot) complainIfHaveCurrentEvent(ctx *Context) (bool, error) {
-	if event := bot.db.GetCurrentEvent(); event != nil {
-		if event.StartedAt.Valid {
-			return true, bot.ReplyAboutEvent(ctx, "already have an active event", event)
-		} else {
-			return true, bot.ReplyAboutEvent(ctx, "already have an event in schedule", event)
+// Finds the event by "last", "current" or its id.
+func (bot *Bot) findEvent(args string) (*Event, error) {
+	var event *Event
+	switch args {
+	case "last":
+		event = bot.db.GetLastEvent()
+	case "current":
+		event = bot.db.GetCurrentEvent()
+	default:
+		id, err := strconv.Atoi(args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid input argument: %s", args)
 		}
+		event = bot.db.GetEvent(id)
 	}
-	return false, nil
+
+	if event == nil {
+		return nil, fmt.Errorf("no such event")
+	}
+	return event, nil
 }
 
-func parseScheduleEventArgs(args string) (coins int, start time.Time, duration Duration, surprise bool, err error) {
+// Picks the key=value options out of the words, and returns the rest of the
+// words as they are.
+func (bot *Bot) parseEventOptions(words []string) (opts EventOptions, rest []string, err error) {
+	opts.PayoutMode = PayoutModeInstant
+	opts.Distribution = EqualSplit{}.String()
+	opts.Eligibility = bot.config.Eligibility
+	opts.Countdown = bot.config.Countdown
+
+	for _, word := range words {
+		kv := strings.SplitN(word, "=", 2)
+		if len(kv) != 2 {
+			rest = append(rest, word)
+			continue
+		}
+
+		key, value := kv[0], kv[1]
+		switch key {
+		case "payout":
+			if value != PayoutModeInstant && value != PayoutModeBatch {
+				err = fmt.Errorf("unknown payout mode '%s', use 'instant' or 'batch'", value)
+				return
+			}
+			opts.PayoutMode = value
+		case "strategy":
+			var strategy DistributionStrategy
+			if strategy, err = ParseDistribution(value); err != nil {
+				return
+			}
+			opts.Distribution = strategy.String()
+		case "late":
+			var percent int
+			percent, err = strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err != nil || percent < 0 || percent >= 100 {
+				err = fmt.Errorf("the late pool should be a percent of the coins, from 0 to 99")
+				return
+			}
+			opts.LatePool = percent
+		case "rules":
+			if _, err = ParseRules(value); err != nil {
+				return
+			}
+			opts.Eligibility = value
+		case "countdown":
+			if _, err = parseCountdown(value); err != nil {
+				return
+			}
+			opts.Countdown = value
+		default:
+			err = fmt.Errorf("unknown event option '%s'", key)
+			return
+		}
+	}
+	return
+}
+
+func parseScheduleEventArgs(args string) (coins Coins, start time.Time, duration Duration, surprise bool, err error) {
 	words := strings.Fields(args)
 	if len(words) < 2 {
 		err = fmt.Errorf("insufficient arguments")
 		return
 	}
 
-	coins, err = strconv.Atoi(words[0])
+	coins, err = ParseCoins(words[0])
 	if err != nil {
 		err = fmt.Errorf("could not parse the number of coins: %v", err)
 		return
@@ -490,7 +1437,13 @@ func parseScheduleEventArgs(args string) (coins int, start time.Time, duration D
 		true,
 	}
 
-	timestr := strings.Join(words, " ")
+	start, err = parseStartTime(strings.Join(words, " "))
+	return
+}
+
+// Parses the start time of an event, in ISO or human readable format, which
+// should be in the future.
+func parseStartTime(timestr string) (start time.Time, err error) {
 	ft, _, err := fuzzytime.Extract(timestr)
 	if ft.Empty() {
 		err = fmt.Errorf("unsupported datetime format: %v", timestr)
@@ -0,0 +1,208 @@
+package skyaway
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/therealssj/skyaway/wallet"
+)
+
+var BalanceUnknown = errors.New("the payer does not know its balance")
+
+// Pays out the claimed coins. The bot only talks to a payer, so that staging
+// groups and audit-only events can run without touching the funds.
+type Payer interface {
+	// Prepares a payment to all the outputs at once, returns a reference
+	// to it (a transaction id for real payments) and the function which
+	// makes the payment. The reference is known before any coins move, so
+	// it can be recorded first.
+	Prepare(outputs []wallet.Output) (ref string, pay func() error, err error)
+	// Returns the droplets available for paying, or `BalanceUnknown`.
+	Balance() (uint64, error)
+}
+
+// A payer which can look its payments up, to tell whether a payment which
+// has failed went through anyway, and whether it has been confirmed.
+// Payments of other payers are never considered confirmed.
+type Confirmer interface {
+	Lookup(ref string) (made, confirmed bool, err error)
+}
+
+// Creates the payer the wallet config asks for. Returns nil if no payer is
+// configured.
+func NewPayer(config *WalletConfig) (Payer, error) {
+	switch config.Backend {
+	case "", "rpc":
+		if config.RPC == "" {
+			return nil, nil
+		}
+		client, err := wallet.NewClient(config.RPC, config.Address, config.PublicKey, config.SecretKey)
+		if err != nil {
+			return nil, err
+		}
+		return &RPCPayer{client}, nil
+	case "dryrun":
+		return &DryRunPayer{}, nil
+	case "csv", "json":
+		if config.PayoutFile == "" {
+			return nil, fmt.Errorf("the %s payer needs a payout file", config.Backend)
+		}
+		return &FilePayer{Path: config.PayoutFile, Format: config.Backend}, nil
+	default:
+		return nil, fmt.Errorf("unknown wallet backend: %s", config.Backend)
+	}
+}
+
+// Pays from the giveaway wallet through a skycoin node.
+type RPCPayer struct {
+	*wallet.Client
+}
+
+func (p *RPCPayer) Prepare(outputs []wallet.Output) (string, func() error, error) {
+	tx, err := p.Client.Prepare(outputs)
+	if err != nil {
+		return "", nil, err
+	}
+	return tx.ID, func() error { return p.Inject(tx) }, nil
+}
+
+func (p *RPCPayer) Balance() (uint64, error) {
+	balance, err := p.Client.Balance()
+	return balance.Coins, err
+}
+
+// Only logs the payments, and considers them confirmed right away.
+type DryRunPayer struct {
+	payments int
+}
+
+func (p *DryRunPayer) Prepare(outputs []wallet.Output) (string, func() error, error) {
+	p.payments++
+	ref := fmt.Sprintf("dryrun-%d-%d", time.Now().Unix(), p.payments)
+	return ref, func() error {
+		for _, out := range outputs {
+			log.Printf("dry run %s: %s coins to %s", ref, wallet.FormatDroplets(out.Coins), out.Address)
+		}
+		return nil
+	}, nil
+}
+
+func (p *DryRunPayer) Balance() (uint64, error) {
+	return 0, BalanceUnknown
+}
+
+func (p *DryRunPayer) Lookup(ref string) (bool, bool, error) {
+	return true, true, nil
+}
+
+// Appends the payments to a csv or json lines file, for a treasurer to send
+// them manually. The payments are confirmed as soon as they are written, the
+// bot has nothing more to track.
+type FilePayer struct {
+	Path   string
+	Format string // "csv" or "json"
+
+	payments int
+}
+
+func (p *FilePayer) Prepare(outputs []wallet.Output) (string, func() error, error) {
+	p.payments++
+	now := time.Now()
+	ref := fmt.Sprintf("%s-%d-%d", p.Format, now.Unix(), p.payments)
+	return ref, func() error { return p.write(ref, now, outputs) }, nil
+}
+
+func (p *FilePayer) write(ref string, now time.Time, outputs []wallet.Output) error {
+	file, err := os.OpenFile(p.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the payout file: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat the payout file: %v", err)
+	}
+
+	var rows [][]string
+	for _, out := range outputs {
+		rows = append(rows, []string{
+			ref, now.Format(time.RFC3339), out.Address, wallet.FormatDroplets(out.Coins),
+		})
+	}
+
+	if p.Format == "json" {
+		encoder := json.NewEncoder(file)
+		for _, row := range rows {
+			err := encoder.Encode(map[string]string{
+				"ref": row[0], "time": row[1], "address": row[2], "coins": row[3],
+			})
+			if err != nil {
+				return fmt.Errorf("failed to write the payout file: %v", err)
+			}
+		}
+		return nil
+	}
+
+	writer := csv.NewWriter(file)
+	if info.Size() == 0 {
+		if err := writer.Write([]string{"ref", "time", "address", "coins"}); err != nil {
+			return fmt.Errorf("failed to write the payout file: %v", err)
+		}
+	}
+	writer.WriteAll(rows)
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write the payout file: %v", err)
+	}
+	return nil
+}
+
+func (p *FilePayer) Balance() (uint64, error) {
+	return 0, BalanceUnknown
+}
+
+// The payment is made and confirmed if it has been written to the file.
+func (p *FilePayer) Lookup(ref string) (bool, bool, error) {
+	file, err := os.Open(p.Path)
+	if os.IsNotExist(err) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to open the payout file: %v", err)
+	}
+	defer file.Close()
+
+	if p.Format == "json" {
+		decoder := json.NewDecoder(file)
+		for {
+			var row map[string]string
+			err := decoder.Decode(&row)
+			if err == io.EOF {
+				return false, false, nil
+			}
+			if err != nil {
+				return false, false, fmt.Errorf("failed to read the payout file: %v", err)
+			}
+			if row["ref"] == ref {
+				return true, true, nil
+			}
+		}
+	}
+
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return false, false, fmt.Errorf("failed to read the payout file: %v", err)
+	}
+	for _, row := range rows {
+		if row[0] == ref {
+			return true, true, nil
+		}
+	}
+	return false, false, nil
+}
@@ -0,0 +1,37 @@
+package skyaway
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/therealssj/skyaway/wallet"
+)
+
+func TestFilePayerConfirms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "payouts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, format := range []string{"csv", "json"} {
+		payer := &FilePayer{Path: filepath.Join(dir, "payouts."+format), Format: format}
+		outputs := []wallet.Output{{Address: "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv", Coins: 1500000}}
+
+		ref, pay, err := payer.Prepare(outputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if made, _, err := payer.Lookup(ref); err != nil || made {
+			t.Errorf("%s: the payment is made before it is written: %t, %v", format, made, err)
+		}
+		if err := pay(); err != nil {
+			t.Fatal(err)
+		}
+		if made, confirmed, err := payer.Lookup(ref); err != nil || !made || !confirmed {
+			t.Errorf("%s: expected a confirmed payment, got %t, %t, %v", format, made, confirmed, err)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package skyaway
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/therealssj/skyaway/wallet"
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// Sends the pending payouts and retries the failed ones until the payer takes
+// them, then keeps track of the payments until they get confirmed. A payment
+// the ledger has failed to record is looked up on the next pass rather than
+// made twice.
+func (bot *Bot) processPayouts() {
+	every := time.Minute
+	if bot.config.PayoutEvery.Valid {
+		every = bot.config.PayoutEvery.Duration
+	}
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		bot.recoverPayouts()
+		if err := bot.sendPayouts(); err != nil {
+			log.Printf("%v", err)
+			bot.NotifyAdmins(fmt.Sprintf(
+				"the ledger has failed to record a payment, it is going to be looked up again: %v", err,
+			))
+		}
+		bot.confirmPayouts()
+
+		select {
+		case <-ticker.C:
+		case <-bot.payoutChan:
+		}
+	}
+}
+
+// Settles the payouts left sending by a payment which has failed or has been
+// interrupted. The payment is looked up first: the payouts are only retried
+// if it has not been made.
+func (bot *Bot) recoverPayouts() {
+	payouts, err := bot.db.GetPayoutsSending()
+	if err != nil {
+		log.Printf("failed to get payouts being sent: %v", err)
+		return
+	}
+
+	var refs []string
+	batches := make(map[string][]*Payout)
+	for i := range payouts {
+		p := &payouts[i]
+		if _, found := batches[p.TxID.String]; !found {
+			refs = append(refs, p.TxID.String)
+		}
+		batches[p.TxID.String] = append(batches[p.TxID.String], p)
+	}
+
+	confirmer, canLookup := bot.payer.(Confirmer)
+	for _, ref := range refs {
+		batch := batches[ref]
+
+		made := false
+		if canLookup {
+			if made, _, err = confirmer.Lookup(ref); err != nil {
+				// keep the payouts sending until the payment is known
+				log.Printf("failed to look up payment %s: %v", ref, err)
+				continue
+			}
+		}
+
+		if made {
+			if err := bot.paymentMade(batch, ref); err != nil {
+				log.Printf("%v", err)
+			}
+			continue
+		}
+		for _, p := range batch {
+			cause := fmt.Errorf("payment %s has not been made", ref)
+			log.Printf("failed to send payout %d: %v", p.ID, cause)
+			if err := bot.db.PayoutFailed(p, cause); err != nil {
+				log.Printf("failed to mark payout %d as failed: %v", p.ID, err)
+			}
+		}
+	}
+}
+
+func (bot *Bot) sendPayouts() error {
+	payouts, err := bot.db.GetPayoutsToSend(bot.config.PayoutAttempts)
+	if err != nil {
+		log.Printf("failed to get payouts to send: %v", err)
+		return nil
+	}
+
+	for _, batch := range bot.batchPayouts(payouts) {
+		if err := bot.sendPayoutBatch(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Groups the payouts into transactions. The batch payouts of each event go
+// together, at most `PayoutBatchSize` in a transaction, while the others are
+// sent one by one.
+func (bot *Bot) batchPayouts(payouts []Payout) [][]*Payout {
+	size := bot.config.PayoutBatchSize
+	if size <= 0 {
+		size = 50
+	}
+
+	var batches [][]*Payout
+	open := make(map[int]int) // event id -> index of its last batch
+	for i := range payouts {
+		p := &payouts[i]
+		if !p.Batch {
+			batches = append(batches, []*Payout{p})
+			continue
+		}
+
+		j, found := open[p.EventID]
+		if !found || len(batches[j]) >= size {
+			j = len(batches)
+			open[p.EventID] = j
+			batches = append(batches, nil)
+		}
+		batches[j] = append(batches[j], p)
+	}
+	return batches
+}
+
+// Sends the payouts in one transaction, payouts to the same address are
+// merged into one output. The transaction is recorded before it is sent.
+// Returns an error only if the ledger cannot record that it has been sent.
+func (bot *Bot) sendPayoutBatch(batch []*Payout) error {
+	var outputs []wallet.Output
+	index := make(map[string]int)
+	for _, p := range batch {
+		coins := uint64(p.Coins)
+		if i, found := index[p.Address]; found {
+			outputs[i].Coins += coins
+			continue
+		}
+		index[p.Address] = len(outputs)
+		outputs = append(outputs, wallet.Output{Address: p.Address, Coins: coins})
+	}
+
+	txid, pay, err := bot.payer.Prepare(outputs)
+	if err != nil {
+		for _, p := range batch {
+			log.Printf("failed to send payout %d: %v", p.ID, err)
+			if err := bot.db.PayoutFailed(p, err); err != nil {
+				log.Printf("failed to mark payout %d as failed: %v", p.ID, err)
+			}
+		}
+		return nil
+	}
+
+	if err := bot.db.PayoutsSending(batch, txid); err != nil {
+		// nothing has been paid yet, try again later
+		log.Printf("failed to record %d payouts sending with %s: %v", len(batch), txid, err)
+		return nil
+	}
+
+	if err := pay(); err != nil {
+		// the payment may have gone through anyway, so the payouts are
+		// left sending until it is looked up
+		log.Printf("failed to send %d payouts with %s: %v", len(batch), txid, err)
+		if _, canLookup := bot.payer.(Confirmer); !canLookup {
+			for _, p := range batch {
+				if err := bot.db.PayoutFailed(p, err); err != nil {
+					log.Printf("failed to mark payout %d as failed: %v", p.ID, err)
+				}
+			}
+		}
+		return nil
+	}
+
+	log.Printf("%d payouts sent: %s", len(batch), txid)
+	return bot.paymentMade(batch, txid)
+}
+
+// Marks the payouts as sent by the payment and tells the users about it.
+// The payouts are left sending if the ledger fails, then the payment is
+// going to be looked up again rather than made twice.
+func (bot *Bot) paymentMade(batch []*Payout, txid string) error {
+	if err := bot.db.PayoutsSent(batch, txid); err != nil {
+		return fmt.Errorf("failed to mark %d payouts as sent with %s: %v", len(batch), txid, err)
+	}
+
+	for _, p := range batch {
+		bot.notifyAboutPayout(p)
+	}
+	return nil
+}
+
+func (bot *Bot) confirmPayouts() {
+	confirmer, ok := bot.payer.(Confirmer)
+	if !ok {
+		return
+	}
+
+	payouts, err := bot.db.GetPayoutsToConfirm()
+	if err != nil {
+		log.Printf("failed to get payouts to confirm: %v", err)
+		return
+	}
+
+	for i := range payouts {
+		p := &payouts[i]
+		_, confirmed, err := confirmer.Lookup(p.TxID.String)
+		if err != nil {
+			log.Printf("failed to check payout %d transaction %s: %v", p.ID, p.TxID.String, err)
+			continue
+		}
+		if !confirmed {
+			continue
+		}
+
+		log.Printf("payout %d confirmed", p.ID)
+		if err := bot.db.PayoutConfirmed(p); err != nil {
+			log.Printf("failed to mark payout %d as confirmed: %v", p.ID, err)
+		}
+	}
+}
+
+// Tells the user the coins are on their way. This only works if the user has
+// talked to the bot in private before, so failures are just logged.
+func (bot *Bot) notifyAboutPayout(p *Payout) {
+	msg := tgbotapi.NewMessage(int64(p.UserID), fmt.Sprintf(
+		"%s coins are on their way to %s, reference: %s",
+		p.Coins, p.Address, p.TxID.String,
+	))
+	if _, err := bot.telegram.Send(msg); err != nil {
+		log.Printf("failed to notify user %d about payout %d: %v", p.UserID, p.ID, err)
+	}
+}
+
+// Wakes up the payout processing, so that new claims do not have to wait.
+func (bot *Bot) PokePayouts() {
+	select {
+	case bot.payoutChan <- struct{}{}:
+	default:
+	}
+}
+
+func formatPayout(p *Payout) string {
+	line := fmt.Sprintf(
+		"%d. event %d, user %d: %s coins to %s, %s",
+		p.ID, p.EventID, p.UserID, p.Coins, p.Address, p.Status,
+	)
+	if p.TxID.Valid {
+		line += fmt.Sprintf(", tx %s", p.TxID.String)
+	}
+	if p.Status == PayoutFailed {
+		line += fmt.Sprintf(" (%d attempts, %s)", p.Attempts, p.LastError.String)
+	}
+	return line
+}
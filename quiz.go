@@ -0,0 +1,126 @@
+package skyaway
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tells whether the answer is one of the accepted ones, ignoring case and
+// extra whitespace.
+func (q *QuizQuestion) Accepts(answer string) bool {
+	answer = normalizeAnswer(answer)
+	for _, accepted := range strings.Split(q.Answers, "|") {
+		if normalizeAnswer(accepted) == answer {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeAnswer(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+func (bot *Bot) quizAttempts() int {
+	if bot.config.QuizAttempts > 0 {
+		return bot.config.QuizAttempts
+	}
+	return 3
+}
+
+// Tells whether the user has answered all the quiz questions of the event,
+// which is true if the event has no quiz or the user won nothing in it.
+func (bot *Bot) quizPassed(user *User, event *Event) (bool, error) {
+	questions, err := bot.db.GetQuestions(event.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get quiz questions: %v", err)
+	}
+	if len(questions) == 0 {
+		return true, nil
+	}
+
+	p, err := bot.db.GetParticipant(user, event)
+	if err != nil {
+		return false, err
+	}
+	return p.QuizProgress >= len(questions) || p.Coins == 0, nil
+}
+
+// Walks the participants of a started event through its quiz in private
+// before letting them claim: asks the questions one by one and takes the
+// next message as the answer. Passes the message on to the next handler if
+// there is no quiz, the user has answered it already or won nothing.
+func (bot *Bot) handleQuizAnswer(ctx *Context, text string) (bool, error) {
+	event := bot.db.GetCurrentEvent()
+	if event == nil || !event.StartedAt.Valid {
+		return true, nil
+	}
+
+	questions, err := bot.db.GetQuestions(event.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get quiz questions: %v", err)
+	}
+	if len(questions) == 0 {
+		return true, nil
+	}
+
+	p, err := bot.db.GetParticipant(ctx.User, event)
+	if err == NotParticipating {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get the participant: %v", err)
+	}
+	if p.QuizProgress >= len(questions) || p.ClaimedAt.Valid || p.Coins == 0 {
+		return true, nil
+	}
+
+	attempts := bot.quizAttempts()
+	if p.QuizAttempts >= attempts {
+		return false, bot.Reply(ctx, "you have run out of attempts to answer the quiz of this event, wait for the next one")
+	}
+
+	question := &questions[p.QuizProgress]
+	if !p.QuizAsked {
+		if err := bot.db.QuizAsked(p); err != nil {
+			return false, fmt.Errorf("failed to save the quiz state: %v", err)
+		}
+		return false, bot.Ask(ctx, fmt.Sprintf(
+			"answer the question to claim your coins (%d of %d): %s",
+			p.QuizProgress+1, len(questions), question.Question,
+		))
+	}
+
+	correct := question.Accepts(text)
+	if err := bot.db.QuizAnswered(p, correct); err != nil {
+		return false, fmt.Errorf("failed to save the quiz answer: %v", err)
+	}
+
+	if !correct {
+		left := attempts - p.QuizAttempts
+		if left <= 0 {
+			return false, bot.Reply(ctx, "wrong, and you have run out of attempts, wait for the next event")
+		}
+		if err := bot.db.QuizAsked(p); err != nil {
+			return false, fmt.Errorf("failed to save the quiz state: %v", err)
+		}
+		return false, bot.Ask(ctx, fmt.Sprintf(
+			"wrong, %d attempts left. %s", left, question.Question,
+		))
+	}
+
+	if p.QuizProgress < len(questions) {
+		if err := bot.db.QuizAsked(p); err != nil {
+			return false, fmt.Errorf("failed to save the quiz state: %v", err)
+		}
+		return false, bot.Ask(ctx, fmt.Sprintf(
+			"correct! next question (%d of %d): %s",
+			p.QuizProgress+1, len(questions), questions[p.QuizProgress].Question,
+		))
+	}
+
+	if err := bot.Reply(ctx, "correct, you have passed the quiz!"); err != nil {
+		return false, err
+	}
+	return false, bot.handleClaim(ctx, event, "")
+}
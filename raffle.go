@@ -0,0 +1,98 @@
+package skyaway
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Draws a number of winners like RandomSplit does, but the seed is derived
+// from a secret which is committed to when the event starts and revealed when
+// it ends, so that anyone can check the draw was fixed in advance.
+type RaffleSplit struct {
+	RandomSplit
+}
+
+func (s RaffleSplit) String() string {
+	return fmt.Sprintf("raffle:%d", s.Winners)
+}
+
+// Tells whether the event is a raffle.
+func (e *Event) IsRaffle() bool {
+	strategy, err := ParseDistribution(e.Distribution)
+	if err != nil {
+		return false
+	}
+	_, ok := strategy.(RaffleSplit)
+	return ok
+}
+
+// Returns a fresh raffle secret, hex encoded.
+func newRaffleSecret() (string, error) {
+	var b [32]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate a raffle secret: %v", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// Returns the commitment to the secret: the hex encoded sha256 of the secret
+// string.
+func raffleCommitment(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// Derives the seed of the draw from the secret: the first 8 bytes of the
+// secret as a big endian number, with the top bit cleared.
+func raffleSeed(secret string) (int64, error) {
+	b, err := hex.DecodeString(secret)
+	if err != nil || len(b) < 8 {
+		return 0, fmt.Errorf("malformed raffle secret")
+	}
+	return int64(binary.BigEndian.Uint64(b[:8]) & 0x7fffffffffffffff), nil
+}
+
+// Checks the revealed secret of an ended raffle against the commitment and
+// the seed.
+func (e *Event) verifyReveal() error {
+	if !e.Reveal.Valid {
+		return fmt.Errorf("event %d has not revealed its secret yet", e.ID)
+	}
+	if raffleCommitment(e.Reveal.String) != e.Commitment.String {
+		return fmt.Errorf("the revealed secret of event %d does not match its commitment", e.ID)
+	}
+	seed, err := raffleSeed(e.Reveal.String)
+	if err != nil {
+		return err
+	}
+	if seed != e.Seed.Int64 {
+		return fmt.Errorf("the seed of event %d is not derived from its secret", e.ID)
+	}
+	return nil
+}
+
+// Describes the draw of a raffle, so that anyone can redo it once the secret
+// is revealed: the seed, and the telegram ids of the participants in the
+// order the winners are drawn from.
+func formatDraw(event *Event, participants []Participant) []string {
+	lines := []string{fmt.Sprintf("commitment: %s", event.Commitment.String)}
+	if !event.Reveal.Valid {
+		return append(lines, "reveal: when the event ends")
+	}
+
+	ordered := splitOrder(participants)
+	ids := make([]string, len(ordered))
+	for i, p := range ordered {
+		ids[i] = strconv.Itoa(p.UserID)
+	}
+	return append(lines,
+		fmt.Sprintf("reveal: %s", event.Reveal.String),
+		fmt.Sprintf("seed: %d", event.Seed.Int64),
+		fmt.Sprintf("draw order: %s", strings.Join(ids, " ")),
+	)
+}
@@ -0,0 +1,102 @@
+package skyaway
+
+import (
+	"database/sql"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Follows the recipe published in the README, from the revealed secret to
+// the winners.
+func TestRaffleDraw(t *testing.T) {
+	secret := "ff00000000000001" + "0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	seed, err := raffleSeed(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first 8 bytes, big-endian, with the top bit cleared
+	if seed != 0x7f00000000000001 {
+		t.Fatalf("seed %#x, expected 0x7f00000000000001", seed)
+	}
+
+	event := &Event{
+		Coins:        10 * 1000000,
+		Distribution: "raffle:3",
+		Seed:         sql.NullInt64{Int64: seed, Valid: true},
+		Commitment:   sql.NullString{String: raffleCommitment(secret), Valid: true},
+		Reveal:       sql.NullString{String: secret, Valid: true},
+	}
+	if err := event.verifyReveal(); err != nil {
+		t.Fatal(err)
+	}
+
+	// ordered by telegram id; rand.New(rand.NewSource(seed)).Perm(10)[:3]
+	// is [2 1 3]
+	var users []TempUser
+	for id := 101; id <= 110; id++ {
+		users = append(users, TempUser{ID: id})
+	}
+	shares, err := event.split(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var total Coins
+	winners := make(map[int]bool)
+	for i, share := range shares {
+		total += share
+		if share > 0 {
+			winners[users[i].ID] = true
+		}
+	}
+	if len(winners) != 3 || !winners[102] || !winners[103] || !winners[104] {
+		t.Errorf("winners %v, expected 102, 103 and 104", winners)
+	}
+	if total != event.Coins {
+		t.Errorf("the shares add up to %s, expected %s", total, event.Coins)
+	}
+}
+
+// The published recipe relies on the sequence of math/rand, this catches a
+// change of it.
+func TestRafflePermIsStable(t *testing.T) {
+	perm := rand.New(rand.NewSource(0x7f00000000000001)).Perm(10)
+	expected := []int{2, 1, 3, 7, 5, 6, 4, 8, 9, 0}
+	if !reflect.DeepEqual(perm, expected) {
+		t.Errorf("rand.Perm gives %v, expected %v", perm, expected)
+	}
+}
+
+func TestFormatDraw(t *testing.T) {
+	secret := "ff00000000000001" + "0123456789abcdef0123456789abcdef0123456789abcdef"
+	event := &Event{
+		Seed:       sql.NullInt64{Int64: 0x7f00000000000001, Valid: true},
+		Commitment: sql.NullString{String: raffleCommitment(secret), Valid: true},
+	}
+	participants := []Participant{
+		{UserID: 103, Coins: 1},
+		{UserID: 101},
+		{UserID: 111, Coins: 1, Late: true},
+		{UserID: 102, Coins: 1},
+	}
+
+	lines := formatDraw(event, participants)
+	if len(lines) != 2 || lines[1] != "reveal: when the event ends" {
+		t.Errorf("the draw is shown before the reveal: %q", lines)
+	}
+
+	event.Reveal = sql.NullString{String: secret, Valid: true}
+	lines = formatDraw(event, participants)
+	expected := []string{
+		"commitment: " + raffleCommitment(secret),
+		"reveal: " + secret,
+		"seed: 9151314442816847873",
+		"draw order: 101 102 103",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got\n%s\nexpected\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
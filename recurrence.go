@@ -0,0 +1,128 @@
+package skyaway
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returns the first occurrence strictly after the given time.
+func (r *Recurrence) Next(after time.Time) time.Time {
+	after = after.UTC()
+	t := time.Date(after.Year(), after.Month(), after.Day(), 0, r.TimeOfDay, 0, 0, time.UTC)
+	for !t.After(after) || (r.Weekday.Valid && t.Weekday() != time.Weekday(r.Weekday.Int64)) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+func (r *Recurrence) String() string {
+	day := "day"
+	if r.Weekday.Valid {
+		day = time.Weekday(r.Weekday.Int64).String()
+	}
+	s := fmt.Sprintf(
+		"every %s at %02d:%02d UTC, %s coins for %s",
+		day, r.TimeOfDay/60, r.TimeOfDay%60, r.Coins, niceDuration(r.Duration.Duration),
+	)
+	if r.Surprise {
+		s += ", surprise"
+	}
+	if r.Options != "" {
+		s += ", " + r.Options
+	}
+	if r.Paused {
+		s += " (paused)"
+	}
+	return s
+}
+
+// Parses "[weekday or daily] [HH:MM]" of a recurrence, in UTC.
+func parseRecurrenceTime(day, clock string) (weekday int, timeOfDay int, err error) {
+	weekday = -1
+	day = strings.ToLower(day)
+	if day != "daily" {
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			name := strings.ToLower(d.String())
+			if day == name || day == name[:3] {
+				weekday = int(d)
+			}
+		}
+		if weekday < 0 {
+			return 0, 0, fmt.Errorf("unknown day '%s', use a weekday or 'daily'", day)
+		}
+	}
+
+	hm := strings.SplitN(clock, ":", 2)
+	if len(hm) != 2 {
+		return 0, 0, fmt.Errorf("malformed time '%s', use HH:MM", clock)
+	}
+	hour, herr := strconv.Atoi(hm[0])
+	minute, merr := strconv.Atoi(hm[1])
+	if herr != nil || merr != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, 0, fmt.Errorf("malformed time '%s', use HH:MM", clock)
+	}
+	return weekday, hour*60 + minute, nil
+}
+
+// Schedules the next occurrence of the recurrence which comes first, if no
+// event is current. A recurrence with bad options is paused, and the next
+// one is tried. Returns the scheduled event, nil if there are no recurrences,
+// or an error if the database fails, so that the caller retries later.
+func (bot *Bot) materializeRecurrence() (*Event, error) {
+	for {
+		recurrences, err := bot.db.GetRecurrences(false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get recurrences: %v", err)
+		}
+
+		var next *Recurrence
+		var nextStart time.Time
+		for i := range recurrences {
+			r := &recurrences[i]
+			// never repeat an occurrence, even a cancelled one
+			after := time.Now()
+			if r.LastScheduledAt.Valid && r.LastScheduledAt.Time.After(after) {
+				after = r.LastScheduledAt.Time
+			}
+			start := r.Next(after)
+			if next == nil || start.Before(nextStart) {
+				next, nextStart = r, start
+			}
+		}
+		if next == nil {
+			return nil, nil
+		}
+
+		opts, _, err := bot.parseEventOptions(strings.Fields(next.Options))
+		if err != nil {
+			log.Printf("recurrence %d has bad options: %v", next.ID, err)
+			if _, err := bot.db.PauseRecurrence(next.ID, true); err != nil {
+				return nil, fmt.Errorf("failed to pause recurrence %d: %v", next.ID, err)
+			}
+			bot.NotifyAdmins(fmt.Sprintf(
+				"recurrence %d is paused, its options are bad: %v. Delete it and add it again with good ones.",
+				next.ID, err,
+			))
+			continue
+		}
+
+		id, err := bot.db.ScheduleRecurrence(next, nextStart, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to schedule recurrence %d: %v", next.ID, err)
+		}
+
+		event := bot.db.GetEvent(id)
+		if event == nil {
+			return nil, fmt.Errorf("recurrence %d was not scheduled due to reasons unknown", next.ID)
+		}
+
+		log.Printf("scheduled event %d from recurrence %d", event.ID, next.ID)
+		if !event.Surprise {
+			bot.AnnounceEventWithTitle(event, "A new event has been scheduled!")
+		}
+		return event, nil
+	}
+}
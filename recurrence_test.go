@@ -0,0 +1,38 @@
+package skyaway
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestRecurrenceNext(t *testing.T) {
+	daily := &Recurrence{TimeOfDay: 18 * 60}
+	friday := &Recurrence{
+		Weekday:   sql.NullInt64{Int64: int64(time.Friday), Valid: true},
+		TimeOfDay: 18*60 + 30,
+	}
+	// 2018-03-01 is a thursday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2018, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	for _, test := range []struct {
+		name     string
+		r        *Recurrence
+		after    time.Time
+		expected time.Time
+	}{
+		{"daily, later today", daily, at(1, 12, 0), at(1, 18, 0)},
+		{"daily, strictly after", daily, at(1, 18, 0), at(2, 18, 0)},
+		{"daily, tomorrow", daily, at(1, 19, 0), at(2, 18, 0)},
+		{"weekly, tomorrow", friday, at(1, 19, 0), at(2, 18, 30)},
+		{"weekly, same day", friday, at(2, 9, 0), at(2, 18, 30)},
+		{"weekly, next week", friday, at(2, 18, 30), at(9, 18, 30)},
+		{"another time zone", daily, time.Date(2018, 3, 1, 20, 0, 0, 0, time.FixedZone("UTC+3", 3*3600)), at(1, 18, 0)},
+	} {
+		if next := test.r.Next(test.after); !next.Equal(test.expected) {
+			t.Errorf("%s: got %v, expected %v", test.name, next, test.expected)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package skyaway
 import (
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -14,61 +16,153 @@ const (
 	startEvent
 	announceEventEnd
 	endEvent
+	checkEventFunds
+	retrySchedule
 )
 
-// Returns what to do next (start, stop or nothing) and when
-func (bot *Bot) schedule() (task, time.Time) {
+// How long to wait before scheduling again after a database failure.
+const retryScheduleAfter = time.Minute
+
+// Returns what to do next (start, stop or nothing), when, and to which event
+func (bot *Bot) schedule() (task, time.Time, *Event) {
 	event := bot.db.GetCurrentEvent()
 	if event == nil {
-		return nothing, time.Time{}
+		var err error
+		if event, err = bot.materializeRecurrence(); err != nil {
+			log.Printf("%v, retrying in %s", err, niceDuration(retryScheduleAfter))
+			return retrySchedule, time.Now().Add(retryScheduleAfter), nil
+		}
+		if event == nil {
+			return nothing, time.Time{}, nil
+		}
 	}
 
-	if event.StartedAt.Valid {
-		return endEvent, event.StartedAt.Time.Add(event.Duration.Duration)
+	if event.PausedAt.Valid {
+		// the end is pushed back until the event is resumed
+		return nothing, time.Time{}, nil
+	} else if event.StartedAt.Valid {
+		return endEvent, event.EndsAt(), event
 	} else if event.ScheduledAt.Valid {
-		return startEvent, event.ScheduledAt.Time
+		return startEvent, event.ScheduledAt.Time, event
 	}
 
 	log.Print("The current event is not scheduled, not started and not ended. That should not have happened.")
-	return nothing, time.Time{}
+	return nothing, time.Time{}, nil
+
+}
+
+// Parses comma separated countdown milestones, e.g. "1d,6h,1h,15m,1m", and
+// returns them longest first, without repeats. "none" means no countdown
+// announcements.
+func parseCountdown(spec string) ([]time.Duration, error) {
+	var milestones []time.Duration
+	if spec == "none" {
+		return milestones, nil
+	}
 
+	for _, word := range strings.Split(spec, ",") {
+		if word = strings.TrimSpace(word); word == "" {
+			continue
+		}
+		d, err := parseDuration(word)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("malformed countdown milestone '%s'", word)
+		}
+		milestones = append(milestones, d)
+	}
+	sort.Slice(milestones, func(i, j int) bool {
+		return milestones[i] > milestones[j]
+	})
+
+	var unique []time.Duration
+	for i, m := range milestones {
+		if i == 0 || m != milestones[i-1] {
+			unique = append(unique, m)
+		}
+	}
+	return unique, nil
+}
+
+// Returns when to announce the countdown to the `future` start or end of
+// the event next, or zero time if no announcements are left. Events without
+// countdown milestones are announced every `announce_every`.
+func (bot *Bot) nextAnnouncement(event *Event, future time.Time) time.Time {
+	if event.Countdown == "" {
+		every := bot.config.AnnounceEvery.Duration
+		if every <= 0 {
+			return time.Time{}
+		}
+		if announcements := time.Until(future) / every; announcements > 0 {
+			return future.Add(-announcements * every)
+		}
+		return time.Time{}
+	}
+
+	milestones, err := parseCountdown(event.Countdown)
+	if err != nil {
+		log.Printf("event %d has a bad countdown: %v", event.ID, err)
+		return time.Time{}
+	}
+	for _, m := range milestones {
+		if at := future.Add(-m); at.After(time.Now()) {
+			return at
+		}
+	}
+	return time.Time{}
 }
 
 // Returns a more detailed version than `schedule()`
-// of what to do next (including announcements).
+// of what to do next (including announcements and treasury checks).
 func (bot *Bot) subSchedule() (task, time.Time) {
-	tsk, future := bot.schedule()
-	if tsk == nothing {
-		return nothing, time.Time{}
+	tsk, future, event := bot.schedule()
+	if event == nil {
+		return tsk, future
 	}
 
-	every := bot.config.AnnounceEvery.Duration
+	next, nearFuture := tsk, future
 
-	announcements := time.Until(future) / every
-	if announcements <= 0 {
-		return tsk, future
+	if at := bot.nextAnnouncement(event, future); !at.IsZero() {
+		nearFuture = at
+		switch tsk {
+		case startEvent:
+			next = announceEventStart
+		case endEvent:
+			next = announceEventEnd
+		default:
+			log.Print("unsupported task to subSchedule")
+			return nothing, time.Time{}
+		}
 	}
 
-	nearFuture := future.Add(-announcements * every)
-	switch tsk {
-	case startEvent:
-		return announceEventStart, nearFuture
-	case endEvent:
-		return announceEventEnd, nearFuture
-	default:
-		log.Print("unsupported task to subSchedule")
-		return nothing, time.Time{}
+	if tsk == startEvent && bot.config.TreasuryCheck != TreasuryCheckOff {
+		recheck := future.Add(-bot.fundsRecheckBefore())
+		if recheck.After(time.Now()) && !recheck.After(nearFuture) {
+			return checkEventFunds, recheck
+		}
 	}
+
+	return next, nearFuture
+}
+
+func (bot *Bot) fundsRecheckBefore() time.Duration {
+	if bot.config.TreasuryRecheckBefore.Valid {
+		return bot.config.TreasuryRecheckBefore.Duration
+	}
+	return 10 * time.Minute
 }
 
 func (bot *Bot) perform(tsk task) {
+	if tsk == retrySchedule {
+		// the next loop of maintain schedules again
+		return
+	}
+
 	event := bot.db.GetCurrentEvent()
 	if event == nil {
 		log.Print("failed to perform the scheduled task: no current event")
 		return
 	}
 
-	noctx := &Context{}
 	switch tsk {
 	case announceEventStart:
 		if event.Surprise {
@@ -88,30 +182,40 @@ func (bot *Bot) perform(tsk task) {
 	case startEvent:
 		log.Print("starting the event")
 
-		startedEvent, err := bot.StartCurrentEvent()
-		if err != nil {
-			log.Printf("failed to start event: %v", err)
+		// starting the event updates its status message
+		_, err := bot.StartCurrentEvent()
+		if shortage, ok := err.(*InsufficientFunds); ok {
+			log.Printf("cancelling the event: %v", shortage)
+			bot.NotifyAdmins(fmt.Sprintf("the scheduled event is cancelled: %v", shortage))
+			if _, err := bot.EndCurrentEvent(); err != nil {
+				log.Printf("failed to cancel event: %v", err)
+			}
 			break
 		}
-
-		md := formatEventAsMarkdown(startedEvent, true)
-		md = fmt.Sprintf("*%s*\n%s", "Event has started!", md)
-		if err := bot.Send(noctx, "yell", "markdown", md); err != nil {
-			log.Printf("failed to announce event started: %v", err)
+		if err != nil {
+			log.Printf("failed to start event: %v", err)
 		}
 	case endEvent:
 		log.Print("ending the event")
 
-		endedEvent, err := bot.EndCurrentEvent()
-		if err != nil {
+		// so does ending it
+		if _, err := bot.EndCurrentEvent(); err != nil {
 			log.Printf("failed to end event: %v", err)
-			break
 		}
+	case checkEventFunds:
+		log.Print("checking the funds for the event")
 
-		md := formatEventAsMarkdown(endedEvent, true)
-		md = fmt.Sprintf("*%s*\n%s", "Event has ended!", md)
-		if err := bot.Send(noctx, "yell", "markdown", md); err != nil {
-			log.Printf("failed to announce event ended: %v", err)
+		shortage, err := bot.CheckFunds(event.Coins, event)
+		if err != nil {
+			log.Printf("failed to check funds: %v", err)
+			bot.NotifyAdmins(fmt.Sprintf("could not check the wallet balance for the upcoming event: %v", err))
+			break
+		}
+		if shortage != nil {
+			bot.NotifyAdmins(fmt.Sprintf(
+				"the event starting in %s is short of funds: %v",
+				niceDuration(time.Until(event.ScheduledAt.Time)), shortage,
+			))
 		}
 	default:
 		log.Printf("unsupported task to perform: %v", tsk)
@@ -119,11 +223,6 @@ func (bot *Bot) perform(tsk task) {
 }
 
 func (bot *Bot) maintain() {
-	bot.rescheduleChan = make(chan int)
-	defer func() {
-		close(bot.rescheduleChan)
-	}()
-
 	var timer *time.Timer
 	for {
 		tsk, future := bot.subSchedule()
@@ -150,7 +249,11 @@ func (bot *Bot) maintain() {
 
 // Cause a reschedule to happen. Call this if you modify events, so that the
 // bot could wake itself up at correct times for automatic announcements and
-// event starting/stopping.
+// event starting/stopping. This never blocks, so it is safe to call from the
+// scheduled tasks themselves.
 func (bot *Bot) Reschedule() {
-	bot.rescheduleChan <- 1
+	select {
+	case bot.rescheduleChan <- 1:
+	default:
+	}
 }
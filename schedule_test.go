@@ -0,0 +1,67 @@
+package skyaway
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCountdown(t *testing.T) {
+	for _, test := range []struct {
+		spec     string
+		expected []time.Duration
+	}{
+		{"none", nil},
+		{"", nil},
+		{"1d,6h,1h,15m,1m", []time.Duration{24 * time.Hour, 6 * time.Hour, time.Hour, 15 * time.Minute, time.Minute}},
+		{"15m, 1d ,1h", []time.Duration{24 * time.Hour, time.Hour, 15 * time.Minute}},
+		{"1h,60m,1h,5m", []time.Duration{time.Hour, 5 * time.Minute}},
+		{"2", []time.Duration{2 * time.Hour}},
+	} {
+		milestones, err := parseCountdown(test.spec)
+		if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(milestones, test.expected) {
+			t.Errorf("%q: got %v, expected %v", test.spec, milestones, test.expected)
+		}
+	}
+
+	for _, spec := range []string{"soon", "1h,-5m", "0m", "1h,,x"} {
+		if _, err := parseCountdown(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestNextAnnouncement(t *testing.T) {
+	bot := &Bot{config: &Config{AnnounceEvery: Duration{time.Hour, true}}}
+	future := time.Now().Add(150 * time.Minute)
+
+	for _, test := range []struct {
+		countdown string
+		expected  time.Time
+	}{
+		// the milestones already passed are skipped
+		{"1d,6h,1h,15m", future.Add(-time.Hour)},
+		{"15m,1h", future.Add(-time.Hour)},
+		{"1d,6h", time.Time{}},
+		{"none", time.Time{}},
+		// every hour, counting back from the future
+		{"", future.Add(-2 * time.Hour)},
+	} {
+		event := &Event{Countdown: test.countdown}
+		if at := bot.nextAnnouncement(event, future); !at.Equal(test.expected) {
+			t.Errorf("%q: got %v, expected %v", test.countdown, at, test.expected)
+		}
+	}
+
+	bot.config.AnnounceEvery = Duration{}
+	if at := bot.nextAnnouncement(&Event{}, future); !at.IsZero() {
+		t.Errorf("announced at %v without announce_every", at)
+	}
+	if at := bot.nextAnnouncement(&Event{Countdown: "bad"}, future); !at.IsZero() {
+		t.Errorf("announced at %v with a bad countdown", at)
+	}
+}
@@ -7,11 +7,40 @@ CREATE TABLE botuser (
   last_name  TEXT,
   enlisted   BOOL            NOT NULL DEFAULT TRUE, -- is in the group
   banned     BOOL            NOT NULL DEFAULT FALSE, -- is disabled even if in the group
-  admin      BOOL            NOT NULL DEFAULT FALSE  -- can issue commands
+  admin      BOOL            NOT NULL DEFAULT FALSE, -- can issue commands
+  joined_at  TIMESTAMP WITH TIME zone, -- last time the user joined the group, null if not seen joining
+  first_seen TIMESTAMP WITH TIME zone, -- first message in the group, null if none yet
+  last_seen  TIMESTAMP WITH TIME zone -- last message in the group, null if none yet
 );
 
--- Only one event with null `ended_at` should exist, it is considered the
--- current event (scheduled or started).
+-- The number of messages each user writes in the group per day.
+CREATE TABLE activity (
+  user_id  INT  NOT NULL REFERENCES botuser (id),
+  day      DATE NOT NULL,
+  messages INT  NOT NULL DEFAULT 0,
+  PRIMARY KEY (user_id, day)
+);
+
+-- Payout addresses saved by the users, they are offered as the default when
+-- claiming coins.
+CREATE TABLE saved_address (
+  user_id    INT  PRIMARY KEY REFERENCES botuser (id),
+  address    TEXT NOT NULL,
+  updated_at TIMESTAMP WITH TIME zone NOT NULL DEFAULT now()
+);
+
+-- Every change of a saved address is recorded here for auditing.
+CREATE TABLE address_change (
+  id          SERIAL PRIMARY KEY,
+  user_id     INT NOT NULL REFERENCES botuser (id),
+  old_address TEXT, -- null if no address was saved before
+  new_address TEXT, -- null if the address was cleared
+  changed_at  TIMESTAMP WITH TIME zone NOT NULL DEFAULT now()
+);
+
+-- The events with null `ended_at` are the queue: at most one of them is
+-- started, and the scheduled ones must not overlap. The current event is the
+-- started one, or the scheduled one which starts first.
 -- `scheduled_at`, `started_at`, `ended_at` should never be null simultaneously.
 CREATE TABLE event (
   id             SERIAL PRIMARY KEY,
@@ -19,19 +48,110 @@ CREATE TABLE event (
   scheduled_at   TIMESTAMP WITH TIME zone, -- null if started without schedule
   started_at     TIMESTAMP WITH TIME zone, -- null if not started yet or canceled
   ended_at       TIMESTAMP WITH TIME zone, -- null if current event
-  coins          INT     NOT NULL,
-  surprise       BOOLEAN NOT NULL -- no automatic announcements
+  coins          BIGINT  NOT NULL, -- droplets, a millionth of a coin each
+  surprise       BOOLEAN NOT NULL, -- no automatic announcements
+  payout_mode    TEXT    NOT NULL DEFAULT 'instant', -- 'instant' or 'batch' (all claims are sent when the event ends)
+  distribution   TEXT    NOT NULL DEFAULT 'equal', -- 'equal', 'fcfs:AMOUNT', 'weighted', 'random:N' or 'raffle:N'
+  eligibility    TEXT    NOT NULL DEFAULT '', -- comma separated rules, e.g. 'joined:7d,username'
+  late_pool      INT     NOT NULL DEFAULT 0, -- percent of coins reserved for users who join during the event
+  countdown      TEXT    NOT NULL DEFAULT '', -- milestones to announce before the start and the end, e.g. '1h,15m', or 'none'
+  rollover_in    BIGINT  NOT NULL DEFAULT 0, -- droplets added to `coins` at the start from the leftovers of earlier events
+  rolled_over    BIGINT  NOT NULL DEFAULT 0, -- droplets left unclaimed and put aside for the next event
+  seed           BIGINT, -- seeds the rng which splits the coins, null if not started yet
+  secret         TEXT, -- raffles only: the seed is derived from it, kept hidden until the end
+  commitment     TEXT, -- raffles only: hex sha256 of `secret`, published at the start
+  reveal         TEXT, -- raffles only: `secret` published at the end
+  paused_at      TIMESTAMP WITH TIME zone, -- not null while the claims are paused
+  resumed_at     TIMESTAMP WITH TIME zone, -- the last resume, `duration` is extended by the paused time
+  status_message_id INT, -- the message in the chat which shows the status of the event
+  status_pinned  BOOLEAN NOT NULL DEFAULT false -- whether the bot has pinned `status_message_id`, only while started
 );
 
 -- This table keeps track of user claims in events. The current list of users
 -- is added to this table every time an event starts (with null `claimed_at`).
 -- The number of coins for each user is calculated at the start, and then each
--- claim just sets `claimed_at`.
+-- claim just sets `claimed_at` and `address`. The coins are split by the
+-- `event.distribution` strategy, which gets the users ordered by id and an rng
+-- seeded with `event.seed`, so the split can be re-derived. A claim never
+-- takes more than is left in the pool, so `coins` may shrink on claim.
 CREATE TABLE participant (
   event_id   INT NOT NULL REFERENCES event (id),
   user_id    INT NOT NULL REFERENCES botuser (id),
   username   TEXT,
-  coins      INT NOT NULL, -- precalculated number of coins for the user
+  coins      BIGINT NOT NULL, -- precalculated number of droplets for the user
   claimed_at TIMESTAMP WITH TIME zone, -- null if not claimed yet
+  address    TEXT, -- skycoin address given by the user, null if not claimed yet
+  txid       TEXT, -- transaction which sent the coins, null if not sent yet
+  weight     BIGINT NOT NULL DEFAULT 1, -- activity of the user at the start, for weighted splits
+  late       BOOL   NOT NULL DEFAULT FALSE, -- joined during the event, the coins come from the late pool
+  quiz_progress INT  NOT NULL DEFAULT 0, -- number of quiz questions answered correctly
+  quiz_attempts INT  NOT NULL DEFAULT 0, -- number of wrong answers to the quiz
+  quiz_asked    BOOL NOT NULL DEFAULT FALSE, -- the next message of the user is an answer
   PRIMARY KEY (event_id, user_id)
 );
+
+-- Events which repeat every week or every day. The next occurrence becomes
+-- the current event when there is none.
+CREATE TABLE recurrence (
+  id            SERIAL PRIMARY KEY,
+  weekday       INT, -- 0 is sunday, null for every day
+  time_of_day   INT     NOT NULL, -- minutes since the midnight, utc
+  coins         BIGINT  NOT NULL, -- droplets
+  duration      BIGINT  NOT NULL, -- nanoseconds
+  surprise      BOOLEAN NOT NULL,
+  options       TEXT    NOT NULL DEFAULT '', -- event options, e.g. 'payout=batch strategy=random:5'
+  paused        BOOLEAN NOT NULL DEFAULT FALSE,
+  last_event_id INT REFERENCES event (id), -- the last occurrence scheduled, null if none yet
+  created_at    TIMESTAMP WITH TIME zone NOT NULL DEFAULT now()
+);
+
+-- The coins put aside by the rollover policy. Leftovers of ended events come
+-- in with positive `coins`, and go out with negative `coins` when added to
+-- the next event. The sum is the balance waiting for the next event.
+CREATE TABLE treasury_ledger (
+  id         SERIAL PRIMARY KEY,
+  event_id   INT    NOT NULL REFERENCES event (id),
+  coins      BIGINT NOT NULL, -- droplets
+  created_at TIMESTAMP WITH TIME zone NOT NULL DEFAULT now()
+);
+
+-- Users who were enlisted when the event started, but were left out by the
+-- eligibility rules.
+CREATE TABLE exclusion (
+  event_id INT  NOT NULL REFERENCES event (id),
+  user_id  INT  NOT NULL REFERENCES botuser (id),
+  username TEXT,
+  reason   TEXT NOT NULL,
+  PRIMARY KEY (event_id, user_id)
+);
+
+-- Questions a participant has to answer, in the order of ids, before claiming
+-- the coins of the event.
+CREATE TABLE quiz_question (
+  id       SERIAL PRIMARY KEY,
+  event_id INT  NOT NULL REFERENCES event (id),
+  question TEXT NOT NULL,
+  answers  TEXT NOT NULL -- accepted answers, separated by '|'
+);
+
+-- Every claim gets a payout, which tracks the coins on their way to the
+-- user. Payouts are sent in the background, and the failed ones are retried,
+-- so a claim is never lost even if the wallet is down. The transaction id is
+-- recorded with the 'sending' status before the transaction is injected, and
+-- a payout is only retried once the node has been asked about it, so a claim
+-- is never paid twice either.
+CREATE TABLE payout (
+  id         SERIAL PRIMARY KEY,
+  event_id   INT  NOT NULL,
+  user_id    INT  NOT NULL,
+  address    TEXT NOT NULL, -- destination skycoin address
+  coins      BIGINT NOT NULL, -- droplets
+  status     TEXT NOT NULL DEFAULT 'pending', -- pending, sending, sent, confirmed or failed
+  txid       TEXT, -- null if not sending yet
+  attempts   INT  NOT NULL DEFAULT 0, -- number of times sending was tried
+  last_error TEXT, -- null if never failed
+  batch      BOOL NOT NULL DEFAULT FALSE, -- waits for the event to end, to be sent along with the others
+  created_at TIMESTAMP WITH TIME zone NOT NULL DEFAULT now(),
+  updated_at TIMESTAMP WITH TIME zone NOT NULL DEFAULT now(),
+  FOREIGN KEY (event_id, user_id) REFERENCES participant (event_id, user_id)
+);
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"gopkg.in/telegram-bot-api.v4"
 )
@@ -12,12 +13,15 @@ import (
 type Bot struct {
 	config                 *Config
 	db                     *DB
+	payer                  Payer
+	caps                   *Caps
 	telegram               *tgbotapi.BotAPI
 	commandHandlers        map[string]CommandHandler
 	adminCommandHandlers   map[string]CommandHandler
 	privateMessageHandlers []MessageHandler
 	groupMessageHandlers   []MessageHandler
 	rescheduleChan         chan int
+	payoutChan             chan struct{}
 }
 
 type Context struct {
@@ -31,15 +35,51 @@ type MessageHandler func(*Bot, *Context, string) (bool, error)
 var EventExists = errors.New("already have a current event")
 var EventDoesNotExist = errors.New("no current event")
 
+// Returned when the time of an event would overlap with another event which
+// has not ended.
+type EventsOverlap struct {
+	Event *Event // the other event
+}
+
+func (e *EventsOverlap) Error() string {
+	start := e.Event.StartedAt
+	if !start.Valid {
+		start = e.Event.ScheduledAt
+	}
+	return fmt.Sprintf(
+		"the event would overlap with event %d, which runs from %s for %s",
+		e.Event.ID, start.Time.Format("Jan 2 2006, 15:04:05 -0700"),
+		niceDuration(e.Event.Duration.Duration),
+	)
+}
+
+// Returns `*EventsOverlap` if an event running from `start` for `duration`
+// would overlap with another event, not counting the `except` event.
+func (bot *Bot) checkOverlap(start time.Time, duration Duration, except int) error {
+	other, err := bot.db.GetOverlappingEvent(start, duration, except)
+	if err != nil {
+		return err
+	}
+	if other != nil {
+		return &EventsOverlap{other}
+	}
+	return nil
+}
+
 // Starts the current event immediately and return the event, if it exists.
-// Returns `EventDoesNotExist` otherwise.
+// Returns `EventDoesNotExist` otherwise, or `*InsufficientFunds` if the
+// treasury check refuses the event.
 func (bot *Bot) StartCurrentEvent() (*Event, error) {
 	event := bot.db.GetCurrentEvent()
 	if event == nil {
 		return nil, EventDoesNotExist
 	}
 
-	err := bot.db.StartEvent(event)
+	if err := bot.guardFunds(event.Coins, event); err != nil {
+		return nil, err
+	}
+
+	err := bot.db.StartEvent(event, bot.caps)
 	if err != nil {
 		return nil, fmt.Errorf("failed to start current event: %v", err)
 	}
@@ -58,11 +98,20 @@ func (bot *Bot) EndCurrentEvent() (*Event, error) {
 		return nil, EventDoesNotExist
 	}
 
-	err := bot.db.EndEvent(event)
-	if err != nil {
+	if err := bot.EndEvent(event); err != nil {
 		return nil, fmt.Errorf("failed to end current event: %v", err)
 	}
+	return event, nil
+}
+
+// Unconditionally ends the event immediately, stopping it if started, or
+// cancelling it if scheduled.
+func (bot *Bot) EndEvent(event *Event) error {
+	if err := bot.db.EndEvent(event, bot.config.Rollover); err != nil {
+		return err
+	}
 	defer bot.Reschedule()
+	bot.PokePayouts()
 
 	switch {
 	case event.StartedAt.Valid:
@@ -76,7 +125,7 @@ func (bot *Bot) EndCurrentEvent() (*Event, error) {
 		log.Printf("the ended event was neither started, nor scheduled")
 	}
 
-	return event, nil
+	return nil
 }
 
 // Ends the current event immediately and return the event, if it exists and
@@ -88,8 +137,12 @@ func (bot *Bot) EndCurrentEventIfNeeded() (event *Event, ended bool, err error)
 		err = EventDoesNotExist
 		return
 	}
+	if !event.StartedAt.Valid {
+		return
+	}
 
-	var coins, claimers int
+	var coins Coins
+	var claimers int
 
 	if coins, err = bot.db.CoinsUnclaimed(event); err != nil {
 		return
@@ -99,32 +152,43 @@ func (bot *Bot) EndCurrentEventIfNeeded() (event *Event, ended bool, err error)
 		return
 	}
 
-	if coins > 0 && claimers > 0 {
+	// users joining later may still claim from the late pool
+	if coins > 0 && (claimers > 0 || event.LatePool > 0) {
 		return
 	}
 
-	err = bot.db.EndEvent(event)
+	err = bot.db.EndEvent(event, bot.config.Rollover)
 	if err != nil {
 		err = fmt.Errorf("failed to end current event: %v", err)
 		return
 	}
 	bot.AnnounceEventWithTitle(event, "Event has ended!")
 	defer bot.Reschedule()
+	bot.PokePayouts()
 	ended = true
 	return
 }
 
 // Starts an event immediately with given number of `coins` and `duration`.
 // Returns the current event and `EventExists` error if there already is a
-// current event (scheduled or started). Returns the new event if started
-// successfully
-func (bot *Bot) StartNewEvent(coins int, duration Duration) (*Event, error) {
+// started event, `*EventsOverlap` if it would overlap with a scheduled one,
+// or `*InsufficientFunds` if the treasury check refuses the event. Returns
+// the new event if started successfully
+func (bot *Bot) StartNewEvent(coins Coins, duration Duration, opts EventOptions) (*Event, error) {
 	event := bot.db.GetCurrentEvent()
-	if event != nil {
+	if event != nil && event.StartedAt.Valid {
 		return event, EventExists
 	}
 
-	err := bot.db.StartNewEvent(coins, duration)
+	if err := bot.checkOverlap(time.Now(), duration, 0); err != nil {
+		return nil, err
+	}
+
+	if err := bot.guardFunds(coins, nil); err != nil {
+		return nil, err
+	}
+
+	err := bot.db.StartNewEvent(coins, duration, opts, bot.caps)
 	if err != nil {
 		return nil, fmt.Errorf("failed to start event: %v", err)
 	}
@@ -258,11 +322,48 @@ func (bot *Bot) handleUserJoin(ctx *Context, user *tgbotapi.User) error {
 		log.Printf("failed to save the user")
 		return err
 	}
+	if err := bot.db.UserJoined(dbuser); err != nil {
+		log.Printf("failed to record the join time")
+		return err
+	}
+	if err := bot.offerLatePool(ctx, dbuser); err != nil {
+		return err
+	}
 
 	log.Printf("user joined: %s", dbuser.NameAndTags())
 	return nil
 }
 
+// Lets a user who has joined during the current event claim from its late
+// pool, if it has one.
+func (bot *Bot) offerLatePool(ctx *Context, dbuser *User) error {
+	event := bot.db.GetCurrentEvent()
+	if event == nil || !event.StartedAt.Valid || event.LatePool == 0 {
+		return nil
+	}
+
+	coins, err := bot.db.AddLateParticipant(event, dbuser, bot.caps)
+	if err == NoCoinsLeft {
+		log.Printf("late pool is empty for %s", dbuser.NameAndTags())
+		return nil
+	}
+	if err == EventEnded {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add a late participant: %v", err)
+	}
+	if coins == 0 {
+		return nil
+	}
+
+	log.Printf("late participant %s can claim %s coins", dbuser.NameAndTags(), coins)
+	return bot.Reply(ctx, fmt.Sprintf(
+		"welcome! you can claim %s coins in the current event, send me a private message",
+		coins,
+	))
+}
+
 func (bot *Bot) handleUserLeft(ctx *Context, user *tgbotapi.User) error {
 	if user.ID == bot.telegram.Self.ID {
 		log.Printf("i have left the group")
@@ -321,6 +422,12 @@ func (bot *Bot) handleGroupMessage(ctx *Context) error {
 	}
 
 	if ctx.User != nil {
+		if ctx.message.Text != "" {
+			if err := bot.db.RecordMessage(ctx.User); err != nil {
+				log.Printf("failed to record the message: %v", err)
+			}
+		}
+
 		msgWithoutName, mentioned := bot.removeMyName(ctx.message.Text)
 
 		if mentioned || bot.isReplyToMe(ctx) {
@@ -404,6 +511,8 @@ func NewBot(config Config) (*Bot, error) {
 		config:               &config,
 		commandHandlers:      make(map[string]CommandHandler),
 		adminCommandHandlers: make(map[string]CommandHandler),
+		rescheduleChan:       make(chan int, 1),
+		payoutChan:           make(chan struct{}, 1),
 	}
 	var err error
 
@@ -411,6 +520,21 @@ func NewBot(config Config) (*Bot, error) {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
 
+	if bot.payer, err = NewPayer(&config.Wallet); err != nil {
+		return nil, fmt.Errorf("failed to initialize wallet: %v", err)
+	}
+	if bot.payer == nil {
+		log.Printf("no wallet configured, claimed coins will only be recorded")
+	}
+
+	if bot.caps, err = config.Caps.Parse(); err != nil {
+		return nil, fmt.Errorf("failed to parse the caps: %v", err)
+	}
+
+	if _, err = parseCountdown(config.Countdown); err != nil {
+		return nil, fmt.Errorf("failed to parse the countdown: %v", err)
+	}
+
 	if bot.telegram, err = tgbotapi.NewBotAPI(config.Token); err != nil {
 		return nil, fmt.Errorf("failed to initialize telegram api: %v", err)
 	}
@@ -461,10 +585,9 @@ func (bot *Bot) handleUpdate(update *tgbotapi.Update) error {
 	return bot.handleMessage(&ctx)
 }
 
+// Shows the event under the title in the pinned status message of the event.
 func (bot *Bot) AnnounceEventWithTitle(event *Event, title string) error {
-	md := formatEventAsMarkdown(event, true)
-	md = fmt.Sprintf("*%s*\n%s", title, md)
-	return bot.Send(&Context{}, "yell", "markdown", md)
+	return bot.UpdateStatus(event, title)
 }
 
 func (bot *Bot) Start() error {
@@ -477,6 +600,9 @@ func (bot *Bot) Start() error {
 	}
 
 	go bot.maintain()
+	if bot.payer != nil {
+		go bot.processPayouts()
+	}
 
 	for update := range updates {
 		if err := bot.handleUpdate(&update); err != nil {
@@ -0,0 +1,165 @@
+package skyaway
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+// Formats the status of the event under the title, with the coins and the
+// claimers left while the event runs.
+func (bot *Bot) formatStatus(event *Event, title string) string {
+	md := fmt.Sprintf("*%s*\n%s", title, formatEventAsMarkdown(event, true))
+	if !event.StartedAt.Valid || event.EndedAt.Valid {
+		return md
+	}
+
+	var fields []string
+	if coins, err := bot.db.CoinsUnclaimed(event); err != nil {
+		log.Printf("failed to count the unclaimed coins: %v", err)
+	} else {
+		fields = appendField(fields, "coins left", "%s", coins)
+	}
+	if claimers, err := bot.db.ClaimersLeft(event); err != nil {
+		log.Printf("failed to count the claimers left: %v", err)
+	} else {
+		fields = appendField(fields, "claimers left", "%d", claimers)
+	}
+	if len(fields) > 0 {
+		md += "\n" + strings.Join(fields, "\n")
+	}
+	return md
+}
+
+// Shows the status of the event in its status message, which is edited in
+// place. The first update of the event posts the message. It is pinned while
+// the event runs, and unpinned by the update after the event has ended.
+func (bot *Bot) UpdateStatus(event *Event, title string) error {
+	md := bot.formatStatus(event, title)
+
+	edited := false
+	if event.StatusMessageID.Valid {
+		edit := tgbotapi.NewEditMessageText(bot.config.ChatID, int(event.StatusMessageID.Int64), md)
+		edit.ParseMode = "Markdown"
+		_, err := bot.telegram.Send(edit)
+		switch {
+		case err == nil, strings.Contains(err.Error(), "message is not modified"):
+			edited = true
+		default:
+			// most likely deleted by an admin, post another one
+			log.Printf("failed to edit the status message: %v", err)
+		}
+	}
+
+	if !edited && event.EndedAt.Valid {
+		if err := bot.Send(&Context{}, "yell", "markdown", md); err != nil {
+			return err
+		}
+	} else if !edited {
+		msg := tgbotapi.NewMessage(bot.config.ChatID, md)
+		msg.ParseMode = "Markdown"
+		sent, err := bot.telegram.Send(msg)
+		if err != nil {
+			return fmt.Errorf("failed to post the status message: %v", err)
+		}
+		if err := bot.db.SetStatusMessage(event, sent.MessageID); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case event.EndedAt.Valid && event.StatusPinned:
+		bot.unpinStatus(event)
+	case event.StartedAt.Valid && !event.EndedAt.Valid && !event.StatusPinned:
+		bot.pinStatus(event)
+	}
+	return nil
+}
+
+// Takes the status message of the event out of the chat, e.g. when the event
+// turns into a surprise. Telegram only lets bots delete recent messages, so
+// an older one is blanked instead.
+func (bot *Bot) DeleteStatus(event *Event) error {
+	if !event.StatusMessageID.Valid {
+		return nil
+	}
+
+	_, err := bot.telegram.DeleteMessage(tgbotapi.DeleteMessageConfig{
+		ChatID:    bot.config.ChatID,
+		MessageID: int(event.StatusMessageID.Int64),
+	})
+	if err != nil {
+		log.Printf("failed to delete the status message: %v", err)
+		edit := tgbotapi.NewEditMessageText(
+			bot.config.ChatID, int(event.StatusMessageID.Int64),
+			"this event is no longer announced",
+		)
+		if _, err := bot.telegram.Send(edit); err != nil {
+			log.Printf("failed to blank the status message: %v", err)
+		}
+	}
+	return bot.db.ClearStatusMessage(event)
+}
+
+// Pins the status message of the started event.
+func (bot *Bot) pinStatus(event *Event) {
+	_, err := bot.telegram.PinChatMessage(tgbotapi.PinChatMessageConfig{
+		ChatID:              bot.config.ChatID,
+		MessageID:           int(event.StatusMessageID.Int64),
+		DisableNotification: true,
+	})
+	if err != nil {
+		// the bot needs the rights to pin messages
+		log.Printf("failed to pin the status message: %v", err)
+		return
+	}
+	if err := bot.db.SetStatusPinned(event, true); err != nil {
+		log.Printf("failed to remember the pinned status message: %v", err)
+	}
+}
+
+// Unpins the status message of the event. Telegram unpins whichever message
+// is pinned, so nothing is unpinned if an admin has pinned another one since.
+func (bot *Bot) unpinStatus(event *Event) {
+	pinned, err := bot.pinnedMessage()
+	if err != nil {
+		log.Printf("failed to get the pinned message: %v", err)
+		return
+	}
+	if pinned != nil && pinned.MessageID == int(event.StatusMessageID.Int64) {
+		_, err := bot.telegram.UnpinChatMessage(tgbotapi.UnpinChatMessageConfig{
+			ChatID: bot.config.ChatID,
+		})
+		if err != nil {
+			log.Printf("failed to unpin the status message: %v", err)
+			return
+		}
+	}
+	if err := bot.db.SetStatusPinned(event, false); err != nil {
+		log.Printf("failed to forget the pinned status message: %v", err)
+	}
+}
+
+// Returns the message pinned in the chat, or nil. The Chat of the telegram
+// api does not carry it.
+func (bot *Bot) pinnedMessage() (*tgbotapi.Message, error) {
+	v := url.Values{}
+	v.Add("chat_id", strconv.FormatInt(bot.config.ChatID, 10))
+	resp, err := bot.telegram.MakeRequest("getChat", v)
+	if err != nil {
+		return nil, err
+	}
+
+	var chat struct {
+		PinnedMessage *tgbotapi.Message `json:"pinned_message"`
+	}
+	if err := json.Unmarshal(resp.Result, &chat); err != nil {
+		return nil, err
+	}
+	return chat.PinnedMessage, nil
+}
@@ -0,0 +1,98 @@
+package skyaway
+
+import (
+	"fmt"
+	"log"
+
+	"gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	TreasuryCheckRefuse = "refuse"
+	TreasuryCheckWarn   = "warn"
+	TreasuryCheckOff    = "off"
+)
+
+// Returned when the wallet cannot cover an event on top of what the bot owes
+// already.
+type InsufficientFunds struct {
+	Coins     Coins // the event budget
+	Owed      Coins // unpaid claims and budgets of other events
+	Available Coins // in the wallet
+}
+
+func (e *InsufficientFunds) Error() string {
+	return fmt.Sprintf(
+		"the wallet has %s coins, which is not enough for %s coins of the event on top of %s coins owed already",
+		e.Available, e.Coins, e.Owed,
+	)
+}
+
+// Checks whether the wallet can cover `coins` on top of what is owed already,
+// not counting the `except` event. Returns nil if the payer does not know its
+// balance.
+func (bot *Bot) CheckFunds(coins Coins, except *Event) (*InsufficientFunds, error) {
+	if bot.payer == nil {
+		return nil, nil
+	}
+
+	balance, err := bot.payer.Balance()
+	if err == BalanceUnknown {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the wallet balance: %v", err)
+	}
+
+	owed, err := bot.db.CoinsOwed(except)
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(coins+owed) > balance {
+		return &InsufficientFunds{coins, owed, Coins(balance)}, nil
+	}
+	return nil, nil
+}
+
+// Applies the treasury check policy to an event budget: returns an error if
+// the event should be refused, warns the admins otherwise.
+func (bot *Bot) guardFunds(coins Coins, except *Event) error {
+	if bot.config.TreasuryCheck == TreasuryCheckOff {
+		return nil
+	}
+
+	shortage, err := bot.CheckFunds(coins, except)
+	if err != nil {
+		// do not block events just because the wallet is unreachable
+		log.Printf("treasury check failed: %v", err)
+		bot.NotifyAdmins(fmt.Sprintf("could not check the wallet balance: %v", err))
+		return nil
+	}
+	if shortage == nil {
+		return nil
+	}
+
+	if bot.config.TreasuryCheck == TreasuryCheckWarn {
+		bot.NotifyAdmins(fmt.Sprintf("warning: %v", shortage))
+		return nil
+	}
+	return shortage
+}
+
+// Sends the text to every admin in private. Admins who have never talked to
+// the bot in private cannot be reached, which is only logged.
+func (bot *Bot) NotifyAdmins(text string) {
+	admins, err := bot.db.GetAdmins()
+	if err != nil {
+		log.Printf("failed to get admins to notify: %v", err)
+		return
+	}
+
+	for _, admin := range admins {
+		msg := tgbotapi.NewMessage(int64(admin.ID), text)
+		if _, err := bot.telegram.Send(msg); err != nil {
+			log.Printf("failed to notify admin %s: %v", admin.NameAndTags(), err)
+		}
+	}
+}
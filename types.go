@@ -2,12 +2,16 @@ package skyaway
 
 import (
 	"bytes"
+	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/therealssj/skyaway/wallet"
 )
 
 var nullString = []byte("null")
@@ -21,6 +25,32 @@ func NewDuration(d time.Duration) Duration {
 	return Duration{d, true}
 }
 
+// An amount of skycoins in droplets, a millionth of a coin each.
+type Coins int64
+
+func ParseCoins(s string) (Coins, error) {
+	droplets, err := wallet.ParseDroplets(s)
+	if err != nil {
+		return 0, err
+	}
+	if droplets > math.MaxInt64 {
+		return 0, fmt.Errorf("too many coins: %s", s)
+	}
+	return Coins(droplets), nil
+}
+
+// Formats the amount as a decimal number of coins, without trailing zeros.
+func (c Coins) String() string {
+	sign := ""
+	if c < 0 {
+		sign, c = "-", -c
+	}
+	s := wallet.FormatDroplets(uint64(c))
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	return sign + s
+}
+
 type User struct {
 	ID        int    `json:"id"`
 	UserName  string `db:"username" json:"username,omitempty"`
@@ -29,21 +59,106 @@ type User struct {
 	Enlisted  bool   `json:"enlisted"`
 	Banned    bool   `json:"banned"`
 	Admin     bool   `json:"admin"`
+	// When the user joined the group, null if before the bot recorded it.
+	JoinedAt NullTime `db:"joined_at" json:"joined_at,omitempty"`
+	// The first and the last message of the user in the group.
+	FirstSeen NullTime `db:"first_seen" json:"first_seen,omitempty"`
+	LastSeen  NullTime `db:"last_seen" json:"last_seen,omitempty"`
 
 	exists bool
 }
 
 type Participant struct {
-	EventID   int      `db:"event_id" json:"event_id"`
-	UserID    int      `db:"user_id" json:"user_id"`
-	UserName  string   `db:"username" json:"username,omitempty"`
-	Coins     int      `db:"coins" json:"coins"`
-	ClaimedAt NullTime `db:"claimed_at" json:"claimed_at,omitempty"`
+	EventID   int            `db:"event_id" json:"event_id"`
+	UserID    int            `db:"user_id" json:"user_id"`
+	UserName  string         `db:"username" json:"username,omitempty"`
+	Coins     Coins          `db:"coins" json:"coins"`
+	ClaimedAt NullTime       `db:"claimed_at" json:"claimed_at,omitempty"`
+	Address   sql.NullString `db:"address" json:"address,omitempty"`
+	TxID      sql.NullString `db:"txid" json:"txid,omitempty"`
+	Weight    int64          `db:"weight" json:"weight"`
+	Late      bool           `json:"late"`
+
+	QuizProgress int  `db:"quiz_progress" json:"quiz_progress"`
+	QuizAttempts int  `db:"quiz_attempts" json:"quiz_attempts"`
+	QuizAsked    bool `db:"quiz_asked" json:"quiz_asked"`
+}
+
+type QuizQuestion struct {
+	ID       int    `json:"id"`
+	EventID  int    `db:"event_id" json:"event_id"`
+	Question string `json:"question"`
+	Answers  string `json:"answers"` // separated by '|'
+}
+
+const (
+	PayoutPending   = "pending"
+	PayoutSending   = "sending"
+	PayoutSent      = "sent"
+	PayoutConfirmed = "confirmed"
+	PayoutFailed    = "failed"
+)
+
+type Payout struct {
+	ID        int            `json:"id"`
+	EventID   int            `db:"event_id" json:"event_id"`
+	UserID    int            `db:"user_id" json:"user_id"`
+	Address   string         `json:"address"`
+	Coins     Coins          `json:"coins"`
+	Status    string         `json:"status"`
+	TxID      sql.NullString `db:"txid" json:"txid,omitempty"`
+	Attempts  int            `json:"attempts"`
+	LastError sql.NullString `db:"last_error" json:"last_error,omitempty"`
+	Batch     bool           `json:"batch"`
+	CreatedAt time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time      `db:"updated_at" json:"updated_at"`
+}
+
+type AddressChange struct {
+	ID         int            `json:"id"`
+	UserID     int            `db:"user_id" json:"user_id"`
+	OldAddress sql.NullString `db:"old_address" json:"old_address,omitempty"`
+	NewAddress sql.NullString `db:"new_address" json:"new_address,omitempty"`
+	ChangedAt  time.Time      `db:"changed_at" json:"changed_at"`
 }
 
 type TempUser struct {
-	ID       int    `db:"id"`
-	UserName string `db:"username"`
+	ID        int      `db:"id"`
+	UserName  string   `db:"username"`
+	Weight    int64    `db:"weight"`
+	JoinedAt  NullTime `db:"joined_at"`
+	FirstSeen NullTime `db:"first_seen"`
+}
+
+// The number of messages a user wrote in the group in a day.
+type DailyActivity struct {
+	Day      time.Time `json:"day"`
+	Messages int       `json:"messages"`
+}
+
+// Schedules an event every week or every day, see Bot.materializeRecurrence.
+type Recurrence struct {
+	ID          int           `json:"id"`
+	Weekday     sql.NullInt64 `json:"weekday"`                      // 0 is sunday, null for every day
+	TimeOfDay   int           `db:"time_of_day" json:"time_of_day"` // minutes since the midnight, utc
+	Coins       Coins         `json:"coins"`
+	Duration    Duration      `json:"duration"`
+	Surprise    bool          `json:"surprise"`
+	Options     string        `json:"options"` // event options, as given to /scheduleevent
+	Paused      bool          `json:"paused"`
+	LastEventID sql.NullInt64 `db:"last_event_id" json:"last_event_id,omitempty"`
+	CreatedAt   time.Time     `db:"created_at" json:"created_at"`
+
+	// when the last event was scheduled to start
+	LastScheduledAt NullTime `db:"last_scheduled_at" json:"-"`
+}
+
+// A user left out of an event by the eligibility rules.
+type Exclusion struct {
+	EventID  int    `db:"event_id" json:"event_id"`
+	UserID   int    `db:"user_id" json:"user_id"`
+	UserName string `db:"username" json:"username,omitempty"`
+	Reason   string `json:"reason"`
 }
 
 func (u *User) NameAndTags() string {
@@ -84,8 +199,66 @@ type Event struct {
 	ScheduledAt NullTime `db:"scheduled_at" json:"scheduled_at"`
 	StartedAt   NullTime `db:"started_at" json:"started_at"`
 	EndedAt     NullTime `db:"ended_at" json:"ended_at"`
-	Coins       int      `json:"coins"`
+	Coins       Coins    `json:"coins"`
 	Surprise    bool     `json:"surpruse"`
+	PayoutMode  string   `db:"payout_mode" json:"payout_mode"`
+	// The spec of the distribution strategy, see ParseDistribution.
+	Distribution string `json:"distribution"`
+	// The specs of the eligibility rules, see ParseRules.
+	Eligibility string `json:"eligibility"`
+	// The percent of the coins reserved for the users who join during the
+	// event.
+	LatePool int `db:"late_pool" json:"late_pool"`
+	// The coins added from the leftovers of earlier events, and the coins
+	// left over from this one, if the rollover policy is on.
+	RolloverIn Coins `db:"rollover_in" json:"rollover_in"`
+	RolledOver Coins `db:"rolled_over" json:"rolled_over"`
+	// Seeds the rng which splits the coins, set when the event starts.
+	Seed sql.NullInt64 `db:"seed" json:"seed,omitempty"`
+	// Raffles only: the seed is derived from the secret, which is kept
+	// hidden behind its commitment until the event ends and it is revealed.
+	Secret     sql.NullString `db:"secret" json:"-"`
+	Commitment sql.NullString `db:"commitment" json:"commitment,omitempty"`
+	Reveal     sql.NullString `db:"reveal" json:"reveal,omitempty"`
+	// The claims are refused while paused, the duration is extended by the
+	// paused time on resume.
+	PausedAt  NullTime `db:"paused_at" json:"paused_at,omitempty"`
+	ResumedAt NullTime `db:"resumed_at" json:"resumed_at,omitempty"`
+	// The countdown milestones, see parseCountdown. Announced every
+	// `announce_every` if empty.
+	Countdown string `json:"countdown"`
+	// The message in the chat which is edited to show the status of the
+	// event, pinned by the bot while the event runs.
+	StatusMessageID sql.NullInt64 `db:"status_message_id" json:"status_message_id,omitempty"`
+	StatusPinned    bool          `db:"status_pinned" json:"status_pinned"`
+}
+
+const (
+	PayoutModeInstant = "instant"
+	PayoutModeBatch   = "batch"
+)
+
+// Per event settings, given to /startevent and /scheduleevent as key=value
+// words.
+type EventOptions struct {
+	PayoutMode   string
+	Distribution string
+	Eligibility  string
+	LatePool     int
+	Countdown    string
+}
+
+// Returns the coins reserved for the users who join during the event.
+func (e *Event) LateReserve() Coins {
+	return e.Coins * Coins(e.LatePool) / 100
+}
+
+// Returns when the event ends, or is going to end if it has not yet.
+func (e *Event) EndsAt() time.Time {
+	if e.StartedAt.Valid {
+		return e.StartedAt.Time.Add(e.Duration.Duration)
+	}
+	return e.ScheduledAt.Time.Add(e.Duration.Duration)
 }
 
 func (d Duration) Value() (driver.Value, error) {
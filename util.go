@@ -43,7 +43,7 @@ func appendField(fields []string, name, format string, args ...interface{}) []st
 
 func formatEventAsMarkdown(event *Event, public bool) string {
 	var fields []string
-	fields = appendField(fields, "coins", "%d", event.Coins)
+	fields = appendField(fields, "coins", "%s", event.Coins)
 	if event.StartedAt.Valid {
 		fields = appendField(fields, "started", "%s (%s ago)",
 			event.StartedAt.Time.Format("Jan 2 2006, 15:04:05 -0700"),
@@ -61,19 +61,48 @@ func formatEventAsMarkdown(event *Event, public bool) string {
 			niceDuration(event.Duration.Duration),
 			niceDuration(time.Since(event.EndedAt.Time)),
 		)
+	} else if event.PausedAt.Valid {
+		fields = appendField(fields, "duration", "%s (paused %s ago, claims are not accepted)",
+			niceDuration(event.Duration.Duration),
+			niceDuration(time.Since(event.PausedAt.Time)),
+		)
 	} else {
-		var endsAt time.Time
-		if event.StartedAt.Valid {
-			endsAt = event.StartedAt.Time.Add(event.Duration.Duration)
-		} else {
-			endsAt = event.ScheduledAt.Time.Add(event.Duration.Duration)
-		}
 		fields = appendField(fields, "duration", "%s (ends in %s)",
 			niceDuration(event.Duration.Duration),
-			niceDuration(time.Until(endsAt)),
+			niceDuration(time.Until(event.EndsAt())),
 		)
 	}
 
+	if event.Distribution != "" && event.Distribution != (EqualSplit{}).String() {
+		fields = appendField(fields, "strategy", "%s", event.Distribution)
+	}
+
+	if event.RolloverIn > 0 {
+		fields = appendField(fields, "rolled over", "%s coins from earlier events included", event.RolloverIn)
+	}
+	if event.RolledOver > 0 {
+		fields = appendField(fields, "left over", "%s coins rolled over to the next event", event.RolledOver)
+	}
+
+	if event.LatePool > 0 {
+		fields = appendField(fields, "late joiners", "%s coins reserved", event.LateReserve())
+	}
+
+	if event.Eligibility != "" && !public {
+		fields = appendField(fields, "rules", "%s", event.Eligibility)
+	}
+
+	if event.Commitment.Valid {
+		fields = appendField(fields, "commitment", "`%s`", event.Commitment.String)
+	}
+	if event.Reveal.Valid {
+		fields = appendField(fields, "reveal", "`%s`", event.Reveal.String)
+	}
+
+	if event.PayoutMode == PayoutModeBatch {
+		fields = appendField(fields, "payouts", "all at once when the event ends")
+	}
+
 	if !public {
 		fields = appendField(fields, "surprise", "%t", event.Surprise)
 	}
@@ -81,12 +110,35 @@ func formatEventAsMarkdown(event *Event, public bool) string {
 	return strings.Join(fields, "\n")
 }
 
+// A cheap check to tell an attempt to give an address apart from random
+// chatter, which is not worth complaining about.
+func looksLikeAddress(text string) bool {
+	return len(text) >= 20 && !strings.ContainsAny(text, " \t\n")
+}
+
+// Tells whether the user said yes.
+func isConfirmation(text string) bool {
+	switch strings.ToLower(text) {
+	case "yes", "y", "ok", "sure":
+		return true
+	}
+	return false
+}
+
+// Parses a number of hours, a number of days with a "d" suffix, or a go
+// duration.
 func parseDuration(args string) (time.Duration, error) {
 	hours, err := strconv.ParseFloat(args, 64)
 	if err == nil {
 		return time.Second * time.Duration(hours*3600), nil
 	}
 
+	if days := strings.TrimSuffix(args, "d"); days != args {
+		if days, err := strconv.ParseFloat(days, 64); err == nil {
+			return time.Second * time.Duration(days*24*3600), nil
+		}
+	}
+
 	return time.ParseDuration(args)
 }
 
@@ -0,0 +1,328 @@
+// Package wallet talks to a skycoin node over its JSON-RPC interface to check
+// the balance of the giveaway address and to send coins from it. Transactions
+// are built and signed locally, the node only gets to inject them.
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+const DropletsPerCoin = 1000000
+
+var InsufficientBalance = errors.New("insufficient balance")
+var NothingToSend = errors.New("nothing to send")
+var InvalidSecretKey = errors.New("invalid secret key")
+
+// An error reported by the node itself, as opposed to failing to reach it.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+type Client struct {
+	rpc     string
+	http    *http.Client
+	address cipher.Address
+	seckey  cipher.SecKey
+}
+
+type Output struct {
+	Address string
+	Coins   uint64 // droplets
+}
+
+type Balance struct {
+	Coins uint64 // droplets
+	Hours uint64
+}
+
+// An output of the giveaway address, as reported by the node.
+type readableOutput struct {
+	Hash  string `json:"hash"`
+	Coins string `json:"coins"`
+	Hours uint64 `json:"calculated_hours"`
+}
+
+type unspent struct {
+	hash  cipher.SHA256
+	coins uint64 // droplets
+	hours uint64
+}
+
+// Creates a client sending coins from `giveawayAddress` through the node at `rpc`
+// (the url of its webrpc endpoint). The secret key has to own the giveaway
+// address, the public key is optional and only used to double check it.
+func NewClient(rpc, giveawayAddress, publicKey, secretKey string) (*Client, error) {
+	if rpc == "" {
+		return nil, errors.New("no rpc url")
+	}
+
+	giveaway, err := cipher.DecodeBase58Address(giveawayAddress)
+	if err != nil {
+		return nil, fmt.Errorf("bad giveaway address '%s': %v", giveawayAddress, err)
+	}
+
+	seckey, err := cipher.SecKeyFromHex(secretKey)
+	if err != nil {
+		return nil, InvalidSecretKey
+	}
+	pubkey, err := cipher.PubKeyFromSecKey(seckey)
+	if err != nil {
+		return nil, InvalidSecretKey
+	}
+	if publicKey != "" && !strings.EqualFold(pubkey.Hex(), publicKey) {
+		return nil, errors.New("the public key does not match the secret key")
+	}
+	if cipher.AddressFromPubKey(pubkey) != giveaway {
+		return nil, errors.New("the secret key does not own the giveaway address")
+	}
+
+	return &Client{
+		rpc:     rpc,
+		http:    &http.Client{Timeout: 30 * time.Second},
+		address: giveaway,
+		seckey:  seckey,
+	}, nil
+}
+
+func (c *Client) Address() string {
+	return c.address.String()
+}
+
+func (c *Client) call(method string, params, result interface{}) error {
+	request, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "1",
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s request: %v", method, err)
+	}
+
+	resp, err := c.http.Post(c.rpc, "application/json", bytes.NewReader(request))
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("failed to decode %s response (%s): %v", method, resp.Status, err)
+	}
+	if response.Error != nil {
+		return response.Error
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(response.Result, result); err != nil {
+		return fmt.Errorf("failed to decode %s result: %v", method, err)
+	}
+	return nil
+}
+
+// Returns the outputs of the giveaway address which are not being spent by
+// unconfirmed transactions yet.
+func (c *Client) unspentOutputs() ([]unspent, error) {
+	var result struct {
+		Outputs struct {
+			Head     []readableOutput `json:"head_outputs"`
+			Outgoing []readableOutput `json:"outgoing_outputs"`
+		} `json:"outputs"`
+	}
+	if err := c.call("get_outputs", []string{c.address.String()}, &result); err != nil {
+		return nil, err
+	}
+
+	spent := make(map[string]bool)
+	for _, out := range result.Outputs.Outgoing {
+		spent[out.Hash] = true
+	}
+
+	var outputs []unspent
+	for _, out := range result.Outputs.Head {
+		if spent[out.Hash] {
+			continue
+		}
+
+		coins, err := ParseDroplets(out.Coins)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := cipher.SHA256FromHex(out.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("bad output hash from the node: %s", out.Hash)
+		}
+		outputs = append(outputs, unspent{hash: hash, coins: coins, hours: out.Hours})
+	}
+	return outputs, nil
+}
+
+// Returns the spendable balance of the giveaway address.
+func (c *Client) Balance() (Balance, error) {
+	var balance Balance
+
+	outputs, err := c.unspentOutputs()
+	if err != nil {
+		return balance, err
+	}
+
+	for _, out := range outputs {
+		balance.Coins += out.coins
+		balance.Hours += out.hours
+	}
+	return balance, nil
+}
+
+// A transaction built and signed locally, which has not been injected yet.
+type Transaction struct {
+	ID  string
+	raw []byte
+}
+
+// Builds a transaction paying to all the outputs at once and signs it. The
+// id of the transaction is known before it is injected, so that it can be
+// looked up if the injection fails halfway.
+func (c *Client) Prepare(outputs []Output) (*Transaction, error) {
+	if len(outputs) == 0 {
+		return nil, NothingToSend
+	}
+
+	var tx transaction
+	var total uint64
+	for _, out := range outputs {
+		addr, err := cipher.DecodeBase58Address(out.Address)
+		if err != nil {
+			return nil, fmt.Errorf("bad address '%s': %v", out.Address, err)
+		}
+		if out.Coins == 0 {
+			return nil, fmt.Errorf("cannot send zero coins to %s", out.Address)
+		}
+		tx.out = append(tx.out, transactionOutput{address: addr, coins: out.Coins})
+		total += out.Coins
+	}
+
+	available, err := c.unspentOutputs()
+	if err != nil {
+		return nil, err
+	}
+
+	// spend the biggest outputs first to keep the transaction small
+	sort.Slice(available, func(i, j int) bool {
+		return available[i].coins > available[j].coins
+	})
+
+	var coins, hours uint64
+	for _, out := range available {
+		if coins >= total {
+			break
+		}
+		tx.in = append(tx.in, out.hash)
+		coins += out.coins
+		hours += out.hours
+	}
+	if coins < total {
+		return nil, InsufficientBalance
+	}
+
+	// at least half of the coin hours has to be burned as the fee, the
+	// rest goes back to the giveaway address along with the change
+	if change := coins - total; change > 0 {
+		tx.out = append(tx.out, transactionOutput{
+			address: c.address,
+			coins:   change,
+			hours:   hours / 2,
+		})
+	} else {
+		tx.out[0].hours = hours / 2
+	}
+
+	if err := tx.sign(c.seckey); err != nil {
+		return nil, fmt.Errorf("failed to sign the transaction: %v", err)
+	}
+	return &Transaction{ID: tx.id(), raw: tx.serialize()}, nil
+}
+
+// Injects the prepared transaction into the network.
+func (c *Client) Inject(tx *Transaction) error {
+	var txid string
+	if err := c.call("inject_transaction", []string{hex.EncodeToString(tx.raw)}, &txid); err != nil {
+		return err
+	}
+	if txid != tx.ID {
+		return fmt.Errorf("the node injected transaction %s as %s", tx.ID, txid)
+	}
+	return nil
+}
+
+// Looks the transaction up, tells whether the node knows it at all and
+// whether it has made it into a block.
+func (c *Client) Lookup(txid string) (found, confirmed bool, err error) {
+	var result struct {
+		Transaction struct {
+			Status struct {
+				Confirmed bool `json:"confirmed"`
+			} `json:"status"`
+		} `json:"transaction"`
+	}
+	err = c.call("get_transaction", []string{txid}, &result)
+	if _, ok := err.(*RPCError); ok {
+		// the node answers with an error for unknown transactions
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return true, result.Transaction.Status.Confirmed, nil
+}
+
+// Parses a decimal amount of coins, as the node formats them, into droplets.
+func ParseDroplets(s string) (uint64, error) {
+	parts := strings.SplitN(s, ".", 2)
+	whole, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad amount of coins '%s': %v", s, err)
+	}
+	// the droplets have to fit an int64
+	if whole > (math.MaxInt64-(DropletsPerCoin-1))/DropletsPerCoin {
+		return 0, fmt.Errorf("bad amount of coins '%s': too many coins", s)
+	}
+
+	var frac uint64
+	if len(parts) == 2 && parts[1] != "" {
+		digits := parts[1]
+		if len(digits) > 6 {
+			return 0, fmt.Errorf("bad amount of coins '%s': too many decimals", s)
+		}
+		digits += strings.Repeat("0", 6-len(digits))
+		if frac, err = strconv.ParseUint(digits, 10, 64); err != nil {
+			return 0, fmt.Errorf("bad amount of coins '%s': %v", s, err)
+		}
+	}
+	return whole*DropletsPerCoin + frac, nil
+}
+
+// Formats droplets as a decimal amount of coins, the way the node does.
+func FormatDroplets(droplets uint64) string {
+	return fmt.Sprintf("%d.%06d", droplets/DropletsPerCoin, droplets%DropletsPerCoin)
+}
@@ -0,0 +1,227 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// A fake skycoin node answering the webrpc methods the client uses.
+type fakeNode struct {
+	outputs   interface{}
+	confirmed bool
+	injected  []string // raw transactions, hex encoded
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Method string   `json:"method"`
+		Params []string `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result interface{}
+	switch request.Method {
+	case "get_outputs":
+		result = n.outputs
+	case "inject_transaction":
+		raw, err := hex.DecodeString(request.Params[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n.injected = append(n.injected, request.Params[0])
+		result = cipher.SumSHA256(raw).Hex()
+	case "get_transaction":
+		if request.Params[0] != testTxID {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"id":      "1",
+				"error":   RPCError{-32600, "transaction doesn't exist"},
+			})
+			return
+		}
+		result = map[string]interface{}{
+			"transaction": map[string]interface{}{
+				"status": map[string]interface{}{"confirmed": n.confirmed},
+			},
+		}
+	default:
+		http.Error(w, "unknown method "+request.Method, http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "1",
+		"result":  result,
+	})
+}
+
+func newTestClient(t *testing.T, node *fakeNode) *Client {
+	server := httptest.NewServer(node)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(server.URL, testAddress, testPubKey, testSecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func testOutputs() interface{} {
+	output := func(name, coins string, hours uint64) map[string]interface{} {
+		return map[string]interface{}{
+			"hash":             cipher.SumSHA256([]byte(name)).Hex(),
+			"coins":            coins,
+			"calculated_hours": hours,
+		}
+	}
+	return map[string]interface{}{
+		"outputs": map[string]interface{}{
+			"head_outputs": []interface{}{
+				output("output 1", "3.000000", 42),
+				output("output 2", "0.750000", 10),
+				output("output 3", "5.000000", 100),
+			},
+			// output 3 is being spent already
+			"outgoing_outputs": []interface{}{
+				output("output 3", "5.000000", 100),
+			},
+		},
+	}
+}
+
+func TestNewClientChecksKeys(t *testing.T) {
+	if _, err := NewClient("http://node", testAddress, "", "00"); err != InvalidSecretKey {
+		t.Errorf("expected InvalidSecretKey, got %v", err)
+	}
+	if _, err := NewClient("http://node", testRecipient, "", testSecKey); err == nil {
+		t.Errorf("expected an error for an address the key does not own")
+	}
+	if _, err := NewClient("http://node", testAddress, testPubKey[:64]+"00", testSecKey); err == nil {
+		t.Errorf("expected an error for a mismatching public key")
+	}
+}
+
+func TestBalance(t *testing.T) {
+	client := newTestClient(t, &fakeNode{outputs: testOutputs()})
+
+	balance, err := client.Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Coins != 3750000 || balance.Hours != 52 {
+		t.Errorf("balance %+v, expected 3750000 droplets and 52 hours", balance)
+	}
+}
+
+func TestPrepareAndInject(t *testing.T) {
+	node := &fakeNode{outputs: testOutputs()}
+	client := newTestClient(t, node)
+
+	prepared, err := client.Prepare([]Output{{Address: testRecipient, Coins: 1500000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(node.injected) != 0 {
+		t.Fatalf("a transaction has been injected before Inject")
+	}
+	if err := client.Inject(prepared); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.injected) != 1 {
+		t.Fatalf("%d transactions injected, expected 1", len(node.injected))
+	}
+	raw, _ := hex.DecodeString(node.injected[0])
+	if prepared.ID != cipher.SumSHA256(raw).Hex() {
+		t.Errorf("txid %s does not match the injected transaction", prepared.ID)
+	}
+
+	// the biggest output is enough, half of its hours go with the change
+	tx := &transaction{
+		in: []cipher.SHA256{cipher.SumSHA256([]byte("output 1"))},
+		out: []transactionOutput{
+			{address: mustDecode(t, testRecipient), coins: 1500000},
+			{address: mustDecode(t, testAddress), coins: 1500000, hours: 21},
+		},
+	}
+	tx.innerHash = tx.hashInner()
+	if got := hex.EncodeToString(raw[5:37]); got != tx.innerHash.Hex() {
+		t.Errorf("inner hash %s, expected %s", got, tx.innerHash.Hex())
+	}
+
+	var sig cipher.Sig
+	copy(sig[:], raw[41:106])
+	hash := cipher.AddSHA256(tx.innerHash, tx.in[0])
+	if err := cipher.VerifyAddressSignedHash(cipher.MustDecodeBase58Address(testAddress), sig, hash); err != nil {
+		t.Errorf("the input signature does not verify: %v", err)
+	}
+}
+
+func TestSendInsufficientBalance(t *testing.T) {
+	client := newTestClient(t, &fakeNode{outputs: testOutputs()})
+
+	_, err := client.Prepare([]Output{{Address: testRecipient, Coins: 5000000}})
+	if err != InsufficientBalance {
+		t.Errorf("expected InsufficientBalance, got %v", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	node := &fakeNode{confirmed: true}
+	client := newTestClient(t, node)
+
+	found, confirmed, err := client.Lookup(testTxID)
+	if err != nil || !found || !confirmed {
+		t.Errorf("expected a confirmed transaction, got %t, %t, %v", found, confirmed, err)
+	}
+
+	found, _, err = client.Lookup("00")
+	if err != nil || found {
+		t.Errorf("expected an unknown transaction, got %t, %v", found, err)
+	}
+}
+
+func TestLookupUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	client, err := NewClient(server.URL, testAddress, "", testSecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	// an unreachable node must not be mistaken for an unknown transaction
+	if _, _, err := client.Lookup(testTxID); err == nil {
+		t.Errorf("expected an error from an unreachable node")
+	}
+}
+
+func TestParseDroplets(t *testing.T) {
+	for s, expected := range map[string]uint64{
+		"0":                    0,
+		"1.5":                  1500000,
+		"0.000001":             1,
+		"9223372036853.999999": math.MaxInt64/DropletsPerCoin*DropletsPerCoin - 1,
+	} {
+		droplets, err := ParseDroplets(s)
+		if err != nil || droplets != expected {
+			t.Errorf("ParseDroplets(%q) = %d, %v, expected %d", s, droplets, err, expected)
+		}
+	}
+
+	// 18446744073710 coins would wrap around to 0.448384
+	for _, s := range []string{"9223372036854", "18446744073710", "1.0000001", "x"} {
+		if droplets, err := ParseDroplets(s); err == nil {
+			t.Errorf("ParseDroplets(%q) = %d, expected an error", s, droplets)
+		}
+	}
+}
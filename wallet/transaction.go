@@ -0,0 +1,87 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// A skycoin transaction, laid out the way the node expects it on the wire.
+type transaction struct {
+	innerHash cipher.SHA256
+	sigs      []cipher.Sig
+	in        []cipher.SHA256
+	out       []transactionOutput
+}
+
+type transactionOutput struct {
+	address cipher.Address
+	coins   uint64 // droplets
+	hours   uint64
+}
+
+func (out transactionOutput) serialize(buf *bytes.Buffer) {
+	buf.WriteByte(out.address.Version)
+	buf.Write(out.address.Key[:])
+	binary.Write(buf, binary.LittleEndian, out.coins)
+	binary.Write(buf, binary.LittleEndian, out.hours)
+}
+
+func (tx *transaction) serializeIn(buf *bytes.Buffer) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(tx.in)))
+	for _, in := range tx.in {
+		buf.Write(in[:])
+	}
+}
+
+func (tx *transaction) serializeOut(buf *bytes.Buffer) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(tx.out)))
+	for _, out := range tx.out {
+		out.serialize(buf)
+	}
+}
+
+func (tx *transaction) hashInner() cipher.SHA256 {
+	var buf bytes.Buffer
+	tx.serializeIn(&buf)
+	tx.serializeOut(&buf)
+	return cipher.SumSHA256(buf.Bytes())
+}
+
+// Signs every input with the secret key, the inputs are all expected to
+// belong to the same address.
+func (tx *transaction) sign(seckey cipher.SecKey) error {
+	tx.innerHash = tx.hashInner()
+	tx.sigs = make([]cipher.Sig, len(tx.in))
+	for i, in := range tx.in {
+		sig, err := cipher.SignHash(cipher.AddSHA256(tx.innerHash, in), seckey)
+		if err != nil {
+			return err
+		}
+		tx.sigs[i] = sig
+	}
+	return nil
+}
+
+func (tx *transaction) serialize() []byte {
+	var body bytes.Buffer
+	body.WriteByte(0) // transaction type
+	body.Write(tx.innerHash[:])
+	binary.Write(&body, binary.LittleEndian, uint32(len(tx.sigs)))
+	for _, sig := range tx.sigs {
+		body.Write(sig[:])
+	}
+	tx.serializeIn(&body)
+	tx.serializeOut(&body)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(4+body.Len()))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+// Returns the transaction id, as the node would report it.
+func (tx *transaction) id() string {
+	return cipher.SumSHA256(tx.serialize()).Hex()
+}
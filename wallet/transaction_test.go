@@ -0,0 +1,83 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/skycoin/skycoin/src/cipher"
+)
+
+// Known answers computed with coin.Transaction of skycoin v0.27.1 for the
+// key pair deterministically generated from the seed "skyaway".
+const (
+	testSecKey    = "7d3bfec61659d39456c18dfd53b5659794b14450573a8d09afa42bf6268fabe0"
+	testPubKey    = "03a27f83622ea0d67de74fdb60a1dd70811c9fe3234d0d8235bd8a45c4736c8cd8"
+	testAddress   = "RheLQkDqo8Zadh1n3SAeVgEZtXgeYV338W"
+	testRecipient = "RT3myw2Wzk3YPDgQLNLLxWPe6XBAqmnN2V"
+
+	testInnerHash = "c6f8b2e55dfba00545f3708c0359d205a60b99693098ec8e65c036fba979bb60"
+	testSigHash0  = "9cda3f765444d81722619300775b559256fc0d3018cfde36843846ef7f73c687"
+	testSigHash1  = "f0a7eaa101f884d192ad1aa99b709dea71a35f0e8f4af647387f7981e5d00ce8"
+	testSig0      = "2f294ed81a584b73be46a14316cd3b88074353a08fc35962569b3f1f36207cde36a9dd89781518edfe052e8d489ef72c18a49dc2113606e3535f3ae664ad705001"
+	testSig1      = "f40628adfbbaed580422c860774159990f41c130cc69f712f4c182f00f6b8c491d9a422e0fc06a4f65b99f68f47cd29020d30799bac0450a04a5a6e9e88bf46d00"
+	testRaw       = "3d01000000c6f8b2e55dfba00545f3708c0359d205a60b99693098ec8e65c036fba979bb60020000002f294ed81a584b73be46a14316cd3b88074353a08fc35962569b3f1f36207cde36a9dd89781518edfe052e8d489ef72c18a49dc2113606e3535f3ae664ad705001f40628adfbbaed580422c860774159990f41c130cc69f712f4c182f00f6b8c491d9a422e0fc06a4f65b99f68f47cd29020d30799bac0450a04a5a6e9e88bf46d000200000065dc68cb17e842dc89122cf636d5b04f2ced39011bc5886ba0a026da073194c577c1cff2a71c0e779bf011cae23343c2c559f51ad9436215a5f02289eac8577c02000000003cc2e8bdf6fe29e717f80f53a6d8905ed95f07fd60e31600000000000000000000000000003d6303b7113ebf69efc2000d91df69dca3cd840f10552200000000001500000000000000"
+	testTxID      = "ce6f6c1ca526878f07fd2df0a801325a7e2d48ecf0f19e1dc88b171b8f7abd1e"
+)
+
+func mustDecode(t *testing.T, s string) cipher.Address {
+	addr, err := cipher.DecodeBase58Address(s)
+	if err != nil {
+		t.Fatalf("failed to decode %s: %v", s, err)
+	}
+	return addr
+}
+
+// Two inputs, a payment of 1.5 coins and 2.25 coins of change with 21 hours.
+func testTransaction(t *testing.T) *transaction {
+	return &transaction{
+		in: []cipher.SHA256{
+			cipher.SumSHA256([]byte("output 1")),
+			cipher.SumSHA256([]byte("output 2")),
+		},
+		out: []transactionOutput{
+			{address: mustDecode(t, testRecipient), coins: 1500000},
+			{address: mustDecode(t, testAddress), coins: 2250000, hours: 21},
+		},
+	}
+}
+
+func TestTransactionSerialize(t *testing.T) {
+	tx := testTransaction(t)
+
+	tx.innerHash = tx.hashInner()
+	if tx.innerHash.Hex() != testInnerHash {
+		t.Fatalf("inner hash %s, expected %s", tx.innerHash.Hex(), testInnerHash)
+	}
+
+	tx.sigs = []cipher.Sig{cipher.MustSigFromHex(testSig0), cipher.MustSigFromHex(testSig1)}
+	if raw := hex.EncodeToString(tx.serialize()); raw != testRaw {
+		t.Fatalf("serialized as\n%s\nexpected\n%s", raw, testRaw)
+	}
+	if id := tx.id(); id != testTxID {
+		t.Fatalf("transaction id %s, expected %s", id, testTxID)
+	}
+}
+
+func TestTransactionSign(t *testing.T) {
+	tx := testTransaction(t)
+	if err := tx.sign(cipher.MustSecKeyFromHex(testSecKey)); err != nil {
+		t.Fatal(err)
+	}
+	if tx.innerHash.Hex() != testInnerHash {
+		t.Fatalf("inner hash %s, expected %s", tx.innerHash.Hex(), testInnerHash)
+	}
+
+	// the nonces are random, so the signatures can only be checked against
+	// the address
+	giveaway := cipher.MustDecodeBase58Address(testAddress)
+	for i, hash := range []string{testSigHash0, testSigHash1} {
+		if err := cipher.VerifyAddressSignedHash(giveaway, tx.sigs[i], cipher.MustSHA256FromHex(hash)); err != nil {
+			t.Errorf("signature %d does not verify: %v", i, err)
+		}
+	}
+}